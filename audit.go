@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/snapcrafters/tokenator/internal/ledger"
+	"github.com/snapcrafters/tokenator/internal/tokenator"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Compare the secrets set in each environment with the config",
+	Long: `List the secrets actually set in each configured repo's track environments, and compare
+them with those declared in the config.
+
+Declared secrets that are not set are reported as 'missing', and secrets that are set
+but not declared are reported as 'extra'. Each declared secret is also compared with
+the state file: secrets it doesn't record are 'unrecorded', recorded secrets that are
+no longer set are 'deleted', secrets set since they were recorded are 'modified', a
+Launchpad secret recorded with other credentials is 'stale', and secrets within the
+renewal window of expiring are 'expiring'. The time each secret was last updated, and
+its recorded expiry, are shown alongside. Secret values are never read.`,
+	Args: cobra.NoArgs,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokenator.SetupLogger(verbose)
+
+		cfg, err := parseConfig()
+		if err != nil {
+			return fmt.Errorf("failed to parse config: %w", err)
+		}
+
+		creds, err := parseCreds()
+		if err != nil {
+			return fmt.Errorf("failed to parse credentials: %w", err)
+		}
+
+		backend, err := ledger.NewBackend(cfg.State)
+		if err != nil {
+			return fmt.Errorf("failed to configure state backend: %w", err)
+		}
+
+		l, err := ledger.New(backend)
+		if err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+
+		mgr, err := tokenator.NewManager(*cfg, creds, tokenator.Options{
+			Concurrency: concurrency,
+			Ledger:      l,
+		})
+		if err != nil {
+			return err
+		}
+
+		return mgr.Audit(repositories)
+	},
+}
+
+func init() {
+	auditCmd.Flags().StringSliceVarP(&repositories, "repos", "r", []string{}, "comma-separated subset of repos to audit. If omitted all configured repos will be audited.")
+	auditCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 1, "maximum number of repos to audit concurrently")
+	rootCmd.AddCommand(auditCmd)
+}
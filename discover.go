@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/store"
+	"github.com/snapcrafters/tokenator/internal/tokenator"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var proposedReposPath string
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Compare the config with the packages that exist elsewhere",
+}
+
+var discoverStoreCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Compare the configured snaps with those on the Snap Store account",
+	Long: `List the snaps that the Snap Store account owns or is a collaborator on, and compare
+them with the snaps published from each configured repo.
+
+Snaps on the account that are not configured are reported as 'missing', and configured
+snaps that are not on the account are reported as 'extra'. Use --write to write the
+repo entries proposed for the missing snaps to a file, to merge into the config by hand.
+Extra snaps must be removed from the config by hand.`,
+	Args: cobra.NoArgs,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokenator.SetupLogger(verbose)
+
+		cfg, err := parseConfig()
+		if err != nil {
+			return fmt.Errorf("failed to parse config: %w", err)
+		}
+
+		creds, err := parseCreds()
+		if err != nil {
+			return fmt.Errorf("failed to parse credentials: %w", err)
+		}
+
+		storeClient, err := tokenator.NewStoreClient(*cfg, creds, store.SnapStore)
+		if err != nil {
+			return err
+		}
+		defer logout(storeClient)
+
+		snaps, err := storeClient.AccountSnaps()
+		if err != nil {
+			return fmt.Errorf("failed to list snaps on the store account: %w", err)
+		}
+
+		discovery := tokenator.Discover(*cfg, snaps)
+
+		err = discovery.Print(cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+
+		if proposedReposPath == "" {
+			return nil
+		}
+
+		proposed := &bytes.Buffer{}
+		enc := yaml.NewEncoder(proposed)
+		enc.SetIndent(2)
+
+		// Only the proposed repos are written, rather than the whole config, so that the
+		// comments and formatting of the existing config aren't lost.
+		err = enc.Encode(struct {
+			Repos []config.Repo `yaml:"repos"`
+		}{discovery.ProposedRepos(*cfg)})
+		if err != nil {
+			return fmt.Errorf("failed to marshal proposed repos: %w", err)
+		}
+
+		err = os.WriteFile(proposedReposPath, proposed.Bytes(), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write proposed repos: %w", err)
+		}
+
+		slog.Info("proposed repos written", "path", proposedReposPath)
+		return nil
+	},
+}
+
+func init() {
+	discoverStoreCmd.Flags().StringVarP(&proposedReposPath, "write", "w", "", "write the repos proposed for missing snaps to the specified path")
+	discoverCmd.AddCommand(discoverStoreCmd)
+	rootCmd.AddCommand(discoverCmd)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/snapcrafters/tokenator/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// summaryCaveats maps the caveat keys used by the store and Ubuntu One to the labels
+// shown in the summary printed by the inspect command.
+var summaryCaveats = []struct {
+	label string
+	keys  []string
+}{
+	{"Description", []string{"description"}},
+	{"Packages", []string{"package_id", "packages"}},
+	{"Channels", []string{"channel", "channels"}},
+	{"Permissions", []string{"acl", "permissions"}},
+	{"Valid since", []string{"valid_since"}},
+	{"Expires", []string{"expires"}},
+	{"Account", []string{"account"}},
+	{"Last auth", []string{"last_auth"}},
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [token]",
+	Short: "Show the caveats of a store token",
+	Long: `Decode a store token, such as the value of a SNAP_STORE_* secret, and show the caveats
+on its root and discharge macaroons.
+
+The token is read from standard input if it is not given as an argument.`,
+	Args: cobra.MaximumNArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		encoded, err := readToken(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		info, err := store.InspectToken(encoded)
+		if err != nil {
+			return fmt.Errorf("failed to inspect token: %w", err)
+		}
+
+		return printTokenInfo(os.Stdout, info)
+	},
+}
+
+// readToken returns the token given as an argument, or read from standard input if
+// there is no argument.
+func readToken(cmd *cobra.Command, args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+
+	input, err := io.ReadAll(cmd.InOrStdin())
+	if err != nil {
+		return "", fmt.Errorf("failed to read token from stdin: %w", err)
+	}
+
+	return string(input), nil
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+}
+
+// printTokenInfo writes a summary of the token followed by the full list of caveats on
+// each of its macaroons.
+func printTokenInfo(w io.Writer, info *store.TokenInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	for _, s := range summaryCaveats {
+		values := []string{}
+		for _, key := range s.keys {
+			values = append(values, info.Root.Values(key)...)
+			values = append(values, info.Discharge.Values(key)...)
+		}
+		for i, v := range values {
+			values[i] = decodeCaveatValue(v)
+		}
+		if len(values) > 0 {
+			fmt.Fprintf(tw, "%s:\t%s\n", s.label, strings.Join(values, ", "))
+		}
+	}
+
+	macaroons := []struct {
+		name string
+		info store.MacaroonInfo
+	}{
+		{"Root macaroon", info.Root},
+		{"Discharge macaroon", info.Discharge},
+	}
+
+	for _, m := range macaroons {
+		fmt.Fprintf(tw, "\n%s (location: %s)\n", m.name, m.info.Location)
+		fmt.Fprintln(tw, "  LOCATION\tKEY\tVALUE")
+		for _, c := range m.info.Caveats {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", c.Location, c.Key, c.Value)
+		}
+		for _, c := range m.info.ThirdPartyCaveats {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", c.Location, "third-party", c.ID)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// decodeCaveatValue returns the JSON content of caveat values that are base64 encoded,
+// such as the account details in Ubuntu One caveats, or the value unchanged otherwise.
+func decodeCaveatValue(value string) string {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || !json.Valid(decoded) {
+		return value
+	}
+	return string(decoded)
+}
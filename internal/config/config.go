@@ -1,16 +1,340 @@
 package config
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
 // Config represents the top-level configuration structure for Tokenator.
 type Config struct {
 	Org   string `yaml:"org"`
 	Repos []Repo `yaml:"repos"`
+
+	// RenewalWindow is the number of days before a secret expires during which it
+	// will be rotated. Defaults to 30 days if unset.
+	RenewalWindow int `yaml:"renewal_window,omitempty"`
+
+	// State configures where the record of issued credentials is kept.
+	State State `yaml:"state,omitempty"`
+
+	// PermissionProfiles maps a profile name to the list of store ACLs granted to
+	// tokens issued with that profile.
+	PermissionProfiles map[string][]string `yaml:"permission_profiles,omitempty"`
+
+	// TTLs configures the lifetime of the credentials issued for every repo.
+	TTLs TTLs `yaml:"ttls,omitempty"`
+
+	// Store configures the Snap Store environment that tokens are issued in.
+	Store Store `yaml:"store,omitempty"`
+
+	// Charmhub configures the Charmhub environment that tokens are issued in.
+	Charmhub Store `yaml:"charmhub,omitempty"`
+
+	// EnvironmentPolicy is the protection policy applied to every environment, unless
+	// its track specifies its own.
+	EnvironmentPolicy EnvironmentPolicy `yaml:"environment_policy,omitempty"`
+}
+
+// Store configures which store environment Tokenator talks to. Any URLs specified
+// override those of the named environment, e.g. to point at a local mock store.
+type Store struct {
+	// Environment is the name of the store environment, either "production" (the
+	// default) or "staging".
+	Environment string `yaml:"environment,omitempty"`
+
+	BaseURL    string `yaml:"base_url,omitempty"`
+	StorageURL string `yaml:"storage_url,omitempty"`
+	AuthURL    string `yaml:"auth_url,omitempty"`
+}
+
+// TTLsFor returns the credential lifetimes for the specified repo, where any set in
+// the repo's config take precedence over those set globally.
+func (c *Config) TTLsFor(repo Repo) TTLs {
+	ttls := c.TTLs
+	if repo.TTLs.Store > 0 {
+		ttls.Store = repo.TTLs.Store
+	}
+	if repo.TTLs.PAT > 0 {
+		ttls.PAT = repo.TTLs.PAT
+	}
+	return ttls
+}
+
+// PolicyFor returns the protection policy for the environment of the specified track,
+// where a policy set for the track takes precedence over the global one.
+func (c *Config) PolicyFor(track Track) EnvironmentPolicy {
+	if track.Policy != nil {
+		return *track.Policy
+	}
+	return c.EnvironmentPolicy
+}
+
+// EnvironmentPolicy describes the protection rules of a Github environment. Deployments
+// are always restricted to the track's branches. Only the settings that are set are
+// reconciled on existing environments, so that settings changed by hand are kept unless
+// the policy says otherwise. Settings that are unset take their default value when an
+// environment is created.
+type EnvironmentPolicy struct {
+	// Reviewers is the list of users and teams, one of whom must approve each deployment
+	// to the environment. No approval is required if empty. Defaults to none.
+	Reviewers *[]Reviewer `yaml:"reviewers,omitempty"`
+
+	// WaitTimer is the number of minutes to wait before a deployment proceeds. Defaults to 0.
+	WaitTimer *int `yaml:"wait_timer,omitempty"`
+
+	// CanAdminsBypass allows repo admins to bypass the policy. Defaults to true.
+	CanAdminsBypass *bool `yaml:"can_admins_bypass,omitempty"`
+
+	// PreventSelfReview stops users approving their own deployments. Defaults to true.
+	PreventSelfReview *bool `yaml:"prevent_self_review,omitempty"`
+}
+
+// RequiredReviewers returns the users and teams, one of whom must approve each deployment.
+func (p EnvironmentPolicy) RequiredReviewers() []Reviewer {
+	if p.Reviewers == nil {
+		return []Reviewer{}
+	}
+	return *p.Reviewers
+}
+
+// WaitMinutes returns the number of minutes to wait before a deployment proceeds.
+func (p EnvironmentPolicy) WaitMinutes() int {
+	if p.WaitTimer == nil {
+		return 0
+	}
+	return *p.WaitTimer
+}
+
+// AdminsCanBypass reports whether repo admins can bypass the policy.
+func (p EnvironmentPolicy) AdminsCanBypass() bool {
+	return p.CanAdminsBypass == nil || *p.CanAdminsBypass
+}
+
+// SelfReviewPrevented reports whether users are stopped from approving their own deployments.
+func (p EnvironmentPolicy) SelfReviewPrevented() bool {
+	return p.PreventSelfReview == nil || *p.PreventSelfReview
+}
+
+// Types of environment reviewer.
+const (
+	ReviewerUser = "user"
+	ReviewerTeam = "team"
+)
+
+// Reviewer is a user or team that can approve deployments to an environment.
+type Reviewer struct {
+	// Type is either "user" (the default) or "team".
+	Type string `yaml:"type,omitempty"`
+	// Name is the login of a user, or the slug of a team in the org.
+	Name string `yaml:"name"`
+}
+
+// String returns the reviewer in the form 'type/name'.
+func (r Reviewer) String() string {
+	t := r.Type
+	if t == "" {
+		t = ReviewerUser
+	}
+	return strings.ToLower(t + "/" + r.Name)
+}
+
+// TTLs specifies the lifetime, in days, of each type of credential issued by Tokenator.
+// A zero value means the default lifetime for that type of credential is used.
+type TTLs struct {
+	Store int `yaml:"store,omitempty"`
+	PAT   int `yaml:"pat,omitempty"`
+}
+
+// State configures the backend used to record the credentials issued by Tokenator.
+type State struct {
+	// Backend is the type of backend, either "file" (the default) or "memory".
+	Backend string `yaml:"backend,omitempty"`
+	// Path is the location of the state file when using the "file" backend.
+	Path string `yaml:"path,omitempty"`
 }
 
 // Repo represents a repo for a given snap package which needs configuring.
 type Repo struct {
-	Name   string   `yaml:"name"`
+	Name string `yaml:"name"`
+
+	// Type is the type of package published from the repo, one of "snap" (the
+	// default), "charm" or "rock".
+	Type string `yaml:"type,omitempty"`
+
+	// Snaps is the list of packages published from the repo, whatever their type.
 	Snaps  []string `yaml:"snaps,omitempty"`
-	Tracks []Track  `yaml:"tracks"`
+	Tracks []Track  `yaml:"tracks,omitempty"`
+
+	// Secrets is the list of secrets set in each track's environment, unless the
+	// track specifies its own. Defaults to DefaultSecrets if unset.
+	Secrets []Secret `yaml:"secrets,omitempty"`
+
+	// TTLs overrides the lifetime of the credentials issued for this repo.
+	TTLs TTLs `yaml:"ttls,omitempty"`
+
+	// Variables maps the name of each Actions variable set in every track's environment
+	// to its value. Variables set for a track take precedence.
+	Variables map[string]string `yaml:"variables,omitempty"`
+}
+
+// PackageType returns the type of package published from the repo.
+func (s *Repo) PackageType() string {
+	if s.Type == "" {
+		return PackageTypeSnap
+	}
+	return s.Type
+}
+
+// SnapNames returns the list of snaps published from the repo, which defaults to a
+// single snap with the same name as the repo.
+func (s *Repo) SnapNames() []string {
+	if len(s.Snaps) > 0 {
+		return s.Snaps
+	}
+	return []string{s.Name}
+}
+
+// SecretsFor returns the list of secrets to be set in the environment for the
+// specified track. If the track lists its risks, a store secret is set for each of
+// them in place of any other store secrets. Org-scoped secrets are excluded, as they
+// aren't set in the environment.
+func (s *Repo) SecretsFor(track Track) []Secret {
+	secrets := slices.DeleteFunc(slices.Clone(s.secretsFor(track)), Secret.OrgScoped)
+	if len(track.Risks) == 0 {
+		return secrets
+	}
+
+	secrets = slices.DeleteFunc(secrets, func(secret Secret) bool {
+		return secret.Provider == ProviderStore
+	})
+
+	channels := []string{}
+	for channel := range track.Risks {
+		channels = append(channels, channel)
+	}
+	slices.Sort(channels)
+
+	riskSecrets := []Secret{}
+	for _, channel := range channels {
+		name := track.Risks[channel]
+		if name == "" && !strings.Contains(channel, "/") {
+			name = s.storeSecretPrefix() + strings.ToUpper(channel)
+		}
+		riskSecrets = append(riskSecrets, Secret{Name: name, Provider: ProviderStore, Channel: channel})
+	}
+
+	return append(riskSecrets, secrets...)
+}
+
+// VariablesFor returns the Actions variables to be set in the environment for the
+// specified track, or nil if none are declared. Names are upper case, as in Github, and
+// the placeholders ${repo}, ${track}, ${branch}, ${environment} and ${snaps} in values are
+// replaced with the details of the repo and track.
+func (s *Repo) VariablesFor(track Track) map[string]string {
+	if len(s.Variables) == 0 && len(track.Variables) == 0 {
+		return nil
+	}
+
+	replacer := strings.NewReplacer(
+		"${repo}", s.Name,
+		"${track}", track.Name,
+		"${branch}", track.Branch,
+		"${environment}", track.Environment,
+		"${snaps}", strings.Join(s.SnapNames(), ","),
+	)
+
+	variables := map[string]string{}
+	for _, vars := range []map[string]string{s.Variables, track.Variables} {
+		for name, value := range vars {
+			variables[strings.ToUpper(name)] = replacer.Replace(value)
+		}
+	}
+
+	return variables
+}
+
+// OrgSecretsFor returns the org-scoped secrets that the specified track needs access to.
+func (s *Repo) OrgSecretsFor(track Track) []Secret {
+	return slices.DeleteFunc(slices.Clone(s.secretsFor(track)), func(secret Secret) bool {
+		return !secret.OrgScoped()
+	})
+}
+
+// OrgSecret is a secret set once at the org level, and the repos that can access it.
+type OrgSecret struct {
+	Secret Secret
+	Repos  []string
+}
+
+// OrgSecrets returns each of the org-scoped secrets declared by any track of any repo,
+// ordered by name, along with the sorted list of repos that declare them. If a secret is
+// declared more than once, the first declaration is used.
+func (c *Config) OrgSecrets() []OrgSecret {
+	secrets := []OrgSecret{}
+	for _, repo := range c.Repos {
+		if len(repo.Tracks) == 0 {
+			repo.SetDefaults()
+		}
+
+		for _, track := range repo.Tracks {
+			for _, secret := range repo.OrgSecretsFor(track) {
+				idx := slices.IndexFunc(secrets, func(s OrgSecret) bool { return s.Secret.Name == secret.Name })
+				if idx < 0 {
+					secrets = append(secrets, OrgSecret{Secret: secret})
+					idx = len(secrets) - 1
+				}
+				if !slices.Contains(secrets[idx].Repos, repo.Name) {
+					secrets[idx].Repos = append(secrets[idx].Repos, repo.Name)
+				}
+			}
+		}
+	}
+
+	for _, s := range secrets {
+		slices.Sort(s.Repos)
+	}
+	slices.SortFunc(secrets, func(a, b OrgSecret) int { return strings.Compare(a.Secret.Name, b.Secret.Name) })
+
+	return secrets
+}
+
+// Validate checks the config for declarations that can't be resolved into secrets, such
+// as a channel branch pattern risk without a secret name.
+func (c *Config) Validate() error {
+	for _, repo := range c.Repos {
+		for _, track := range repo.Tracks {
+			for channel, name := range track.Risks {
+				if name == "" && strings.Contains(channel, "/") {
+					return fmt.Errorf("risk '%s' of repo '%s', track '%s' is a branch pattern, and must be given a secret name", channel, repo.Name, track.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// secretsFor returns the secrets configured for the track, or failing that the repo,
+// or failing that the defaults for the repo's package type.
+func (s *Repo) secretsFor(track Track) []Secret {
+	if len(track.Secrets) > 0 {
+		return track.Secrets
+	}
+	if len(s.Secrets) > 0 {
+		return s.Secrets
+	}
+	if s.PackageType() != PackageTypeSnap {
+		return DefaultCharmhubSecrets()
+	}
+	return DefaultSecrets()
+}
+
+// storeSecretPrefix returns the prefix of the default names of the repo's store secrets.
+func (s *Repo) storeSecretPrefix() string {
+	if s.PackageType() != PackageTypeSnap {
+		return "CHARMHUB_TOKEN_"
+	}
+	return "SNAP_STORE_"
 }
 
 // SetDefaults ensures that if no track information is specified for a given snap,
@@ -24,6 +348,94 @@ type Track struct {
 	Name        string `yaml:"name"`
 	Branch      string `yaml:"branch"`
 	Environment string `yaml:"environment"`
+
+	// Secrets is the list of secrets set in the track's environment, overriding
+	// those specified for the repo.
+	Secrets []Secret `yaml:"secrets,omitempty"`
+
+	// Risks maps each risk, or channel branch pattern such as 'edge/*', that the track
+	// needs a store token for to the name of its secret. Risks default to secrets named
+	// after them, e.g. 'SNAP_STORE_EDGE', while branch patterns must be named.
+	Risks map[string]string `yaml:"risks,omitempty"`
+
+	// Policy overrides the protection policy applied to the track's environment.
+	Policy *EnvironmentPolicy `yaml:"policy,omitempty"`
+
+	// Variables maps the name of each Actions variable set in the track's environment
+	// to its value, in addition to those set for the repo.
+	Variables map[string]string `yaml:"variables,omitempty"`
+}
+
+// Types of package published from a repo.
+const (
+	PackageTypeSnap  = "snap"
+	PackageTypeCharm = "charm"
+	PackageTypeRock  = "rock"
+)
+
+// Providers of secret values.
+const (
+	// ProviderStore issues a scoped store token for the repo's snaps.
+	ProviderStore = "store"
+	// ProviderLaunchpad provides the Launchpad remote build credentials.
+	ProviderLaunchpad = "launchpad"
+	// ProviderBotCommit issues a Personal Access Token for the bot account.
+	ProviderBotCommit = "bot-commit"
+)
+
+// Scopes at which a secret is set.
+const (
+	// ScopeEnvironment sets the secret in the environment of each track.
+	ScopeEnvironment = "environment"
+	// ScopeOrg sets the secret once for the org, visible only to the repos declaring it.
+	ScopeOrg = "org"
+)
+
+// Secret describes a single secret set in a Github environment, and which provider
+// issues its value.
+type Secret struct {
+	Name     string `yaml:"name"`
+	Provider string `yaml:"provider"`
+
+	// Channel is the risk level that a store token grants access to, e.g. 'candidate',
+	// or a channel branch pattern such as 'edge/*' or 'latest/edge/*'. Only used by the
+	// 'store' provider.
+	Channel string `yaml:"channel,omitempty"`
+
+	// Profile is the name of the permission profile applied to a store token. If
+	// unset, the default permissions for the channel are used. Only used by the
+	// 'store' provider.
+	Profile string `yaml:"profile,omitempty"`
+
+	// Scope is where the secret is set, either "environment" (the default) or "org". Only
+	// secrets with the same value in every repo, such as those from the 'launchpad'
+	// provider, can be set for the org.
+	Scope string `yaml:"scope,omitempty"`
+}
+
+// OrgScoped reports whether the secret is set for the org, rather than in an environment.
+func (s Secret) OrgScoped() bool {
+	return s.Scope == ScopeOrg
+}
+
+// DefaultSecrets returns the secrets set in each environment if none are configured.
+func DefaultSecrets() []Secret {
+	return []Secret{
+		{Name: "SNAP_STORE_CANDIDATE", Provider: ProviderStore, Channel: "candidate"},
+		{Name: "SNAP_STORE_STABLE", Provider: ProviderStore, Channel: "stable"},
+		{Name: "LP_BUILD_SECRET", Provider: ProviderLaunchpad},
+		{Name: "SNAPCRAFTERS_BOT_COMMIT", Provider: ProviderBotCommit},
+	}
+}
+
+// DefaultCharmhubSecrets returns the secrets set in each environment of a repo that
+// publishes charms or rocks, if none are configured.
+func DefaultCharmhubSecrets() []Secret {
+	return []Secret{
+		{Name: "CHARMHUB_TOKEN_CANDIDATE", Provider: ProviderStore, Channel: "candidate"},
+		{Name: "CHARMHUB_TOKEN_STABLE", Provider: ProviderStore, Channel: "stable"},
+		{Name: "SNAPCRAFTERS_BOT_COMMIT", Provider: ProviderBotCommit},
+	}
 }
 
 // Credentials contains all of the credentials needed for Tokenator to function
@@ -34,6 +446,9 @@ type Credentials struct {
 	// Login details for the snapcraft.io store
 	SnapStore LoginCredentials
 
+	// Login details for Charmhub, which default to those for the snapcraft.io store
+	Charmhub LoginCredentials
+
 	// Credentials for sending build jobs to Launchpad
 	Launchpad string
 
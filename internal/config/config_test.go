@@ -0,0 +1,218 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestVariablesFor(t *testing.T) {
+	repo := Repo{
+		Name:  "gimp",
+		Snaps: []string{"gimp", "gimp-plugins"},
+		Variables: map[string]string{
+			"snap_names": "${snaps}",
+			"Release":    "${repo}-${track}",
+		},
+	}
+	track := Track{
+		Name:        "2.10",
+		Branch:      "stable-2.10",
+		Environment: "Candidate 2.10",
+		Variables: map[string]string{
+			"target":  "${branch} (${environment})",
+			"RELEASE": "override",
+		},
+	}
+
+	// Names are upper-cased, and the track's variables take precedence over the repo's.
+	expected := map[string]string{
+		"SNAP_NAMES": "gimp,gimp-plugins",
+		"RELEASE":    "override",
+		"TARGET":     "stable-2.10 (Candidate 2.10)",
+	}
+
+	variables := repo.VariablesFor(track)
+	if !maps.Equal(variables, expected) {
+		t.Errorf("expected variables %v, got %v", expected, variables)
+	}
+
+	// The repo's variables are expanded for a track that declares none of its own.
+	track.Variables = nil
+	expected = map[string]string{
+		"SNAP_NAMES": "gimp,gimp-plugins",
+		"RELEASE":    "gimp-2.10",
+	}
+
+	variables = repo.VariablesFor(track)
+	if !maps.Equal(variables, expected) {
+		t.Errorf("expected variables %v, got %v", expected, variables)
+	}
+
+	repo.Variables = nil
+	if variables := repo.VariablesFor(track); variables != nil {
+		t.Errorf("expected no variables, got %v", variables)
+	}
+}
+
+func TestSecretsFor(t *testing.T) {
+	launchpad := Secret{Name: "LP_BUILD_SECRET", Provider: ProviderLaunchpad}
+	orgLaunchpad := Secret{Name: "LP_BUILD_SECRET", Provider: ProviderLaunchpad, Scope: ScopeOrg}
+	edge := Secret{Name: "SNAP_STORE_EDGE", Provider: ProviderStore, Channel: "edge"}
+
+	tests := []struct {
+		name     string
+		repo     Repo
+		track    Track
+		expected []Secret
+	}{
+		{
+			name:     "snap defaults",
+			repo:     Repo{Name: "gimp"},
+			expected: DefaultSecrets(),
+		},
+		{
+			name:     "charm defaults",
+			repo:     Repo{Name: "mattermost-k8s", Type: PackageTypeCharm},
+			expected: DefaultCharmhubSecrets(),
+		},
+		{
+			name:     "rock defaults",
+			repo:     Repo{Name: "mattermost", Type: PackageTypeRock},
+			expected: DefaultCharmhubSecrets(),
+		},
+		{
+			name:     "repo secrets",
+			repo:     Repo{Name: "gimp", Secrets: []Secret{edge, launchpad}},
+			expected: []Secret{edge, launchpad},
+		},
+		{
+			name:     "track secrets override the repo's",
+			repo:     Repo{Name: "gimp", Secrets: []Secret{edge, launchpad}},
+			track:    Track{Secrets: []Secret{launchpad}},
+			expected: []Secret{launchpad},
+		},
+		{
+			name:     "org-scoped secrets are excluded",
+			repo:     Repo{Name: "gimp", Secrets: []Secret{edge, orgLaunchpad}},
+			expected: []Secret{edge},
+		},
+		{
+			name:  "risks replace the store secrets",
+			repo:  Repo{Name: "gimp"},
+			track: Track{Risks: map[string]string{"stable": "", "edge/*": "SNAP_STORE_BRANCHES", "beta": "BETA_TOKEN"}},
+			expected: []Secret{
+				{Name: "BETA_TOKEN", Provider: ProviderStore, Channel: "beta"},
+				{Name: "SNAP_STORE_BRANCHES", Provider: ProviderStore, Channel: "edge/*"},
+				{Name: "SNAP_STORE_STABLE", Provider: ProviderStore, Channel: "stable"},
+				launchpad,
+				{Name: "SNAPCRAFTERS_BOT_COMMIT", Provider: ProviderBotCommit},
+			},
+		},
+		{
+			name:  "charm risks are named with the Charmhub prefix",
+			repo:  Repo{Name: "mattermost-k8s", Type: PackageTypeCharm},
+			track: Track{Risks: map[string]string{"edge": ""}},
+			expected: []Secret{
+				{Name: "CHARMHUB_TOKEN_EDGE", Provider: ProviderStore, Channel: "edge"},
+				{Name: "SNAPCRAFTERS_BOT_COMMIT", Provider: ProviderBotCommit},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets := tt.repo.SecretsFor(tt.track)
+			if !slices.Equal(secrets, tt.expected) {
+				t.Errorf("expected secrets %v, got %v", tt.expected, secrets)
+			}
+		})
+	}
+}
+
+func TestOrgSecretsFor(t *testing.T) {
+	orgLaunchpad := Secret{Name: "LP_BUILD_SECRET", Provider: ProviderLaunchpad, Scope: ScopeOrg}
+	stable := Secret{Name: "SNAP_STORE_STABLE", Provider: ProviderStore, Channel: "stable"}
+
+	repo := Repo{Name: "gimp", Secrets: []Secret{stable, orgLaunchpad}}
+
+	secrets := repo.OrgSecretsFor(Track{})
+	if !slices.Equal(secrets, []Secret{orgLaunchpad}) {
+		t.Errorf("expected only the org-scoped secret, got %v", secrets)
+	}
+
+	// The track's own secrets take precedence over the repo's.
+	secrets = repo.OrgSecretsFor(Track{Secrets: []Secret{stable}})
+	if len(secrets) != 0 {
+		t.Errorf("expected no org-scoped secrets, got %v", secrets)
+	}
+
+	// None of the default secrets are org-scoped.
+	if secrets := (&Repo{Name: "gimp"}).OrgSecretsFor(Track{}); len(secrets) != 0 {
+		t.Errorf("expected no org-scoped secrets by default, got %v", secrets)
+	}
+}
+
+func TestOrgSecrets(t *testing.T) {
+	orgLaunchpad := Secret{Name: "LP_BUILD_SECRET", Provider: ProviderLaunchpad, Scope: ScopeOrg}
+	orgBot := Secret{Name: "BOT_COMMIT", Provider: ProviderBotCommit, Scope: ScopeOrg}
+
+	cfg := Config{
+		Repos: []Repo{
+			// A repo without tracks is given the default track.
+			{Name: "vlc", Secrets: []Secret{orgLaunchpad}},
+			{
+				Name: "gimp",
+				Tracks: []Track{
+					{Name: "latest", Secrets: []Secret{orgLaunchpad, orgBot}},
+					{Name: "2.10", Secrets: []Secret{orgLaunchpad}},
+				},
+			},
+			{Name: "inkscape"},
+		},
+	}
+
+	expected := []OrgSecret{
+		{Secret: orgBot, Repos: []string{"gimp"}},
+		{Secret: orgLaunchpad, Repos: []string{"gimp", "vlc"}},
+	}
+
+	secrets := cfg.OrgSecrets()
+	if len(secrets) != len(expected) {
+		t.Fatalf("expected org secrets %v, got %v", expected, secrets)
+	}
+
+	for i := range expected {
+		if secrets[i].Secret != expected[i].Secret || !slices.Equal(secrets[i].Repos, expected[i].Repos) {
+			t.Errorf("expected org secret %v, got %v", expected[i], secrets[i])
+		}
+	}
+
+	// The config's repos are left without tracks.
+	if len(cfg.Repos[0].Tracks) != 0 {
+		t.Errorf("expected the config to be left unchanged, got tracks %v", cfg.Repos[0].Tracks)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{
+		Repos: []Repo{
+			{
+				Name: "gimp",
+				Tracks: []Track{
+					{Name: "latest", Risks: map[string]string{"edge": "", "edge/*": "SNAP_STORE_BRANCHES"}},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected the config to be valid, got %v", err)
+	}
+
+	// A branch pattern can't be named after its risk, so must be given a secret name.
+	cfg.Repos[0].Tracks[0].Risks["latest/beta/*"] = ""
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected an error for a branch pattern without a secret name")
+	}
+}
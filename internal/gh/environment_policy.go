@@ -0,0 +1,273 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+	"github.com/snapcrafters/tokenator/internal/config"
+)
+
+// policyDrift describes a single setting of an environment that differs from its policy.
+type policyDrift struct {
+	setting string
+	want    any
+	got     any
+}
+
+// environmentArgs converts a policy into the arguments used to create or update an
+// environment, looking up the ID of each reviewer. Settings that the policy doesn't set
+// are kept from the existing environment if there is one, or take their defaults.
+func (rc *RepoClient) environmentArgs(ctx context.Context, policy config.EnvironmentPolicy, existing *github.Environment) (*github.CreateUpdateEnvironment, error) {
+	t := true
+	f := false
+	canAdminsBypass := policy.AdminsCanBypass()
+	preventSelfReview := policy.SelfReviewPrevented()
+	waitTimer := policy.WaitMinutes()
+
+	var reviewers []*github.EnvReviewers
+	if existing != nil {
+		rules := protectionRules(existing)
+
+		if policy.CanAdminsBypass == nil && existing.CanAdminsBypass != nil {
+			canAdminsBypass = *existing.CanAdminsBypass
+		}
+		if policy.PreventSelfReview == nil && rules.preventSelfReview != nil {
+			preventSelfReview = *rules.preventSelfReview
+		}
+		if policy.WaitTimer == nil {
+			waitTimer = rules.waitTimer
+		}
+		if policy.Reviewers == nil {
+			reviewers = rules.reviewers
+		}
+	}
+
+	if reviewers == nil {
+		reviewers = []*github.EnvReviewers{}
+		for _, r := range policy.RequiredReviewers() {
+			reviewer, err := rc.lookupReviewer(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			reviewers = append(reviewers, reviewer)
+		}
+	}
+
+	return &github.CreateUpdateEnvironment{
+		WaitTimer:       &waitTimer,
+		Reviewers:       reviewers,
+		CanAdminsBypass: &canAdminsBypass,
+		DeploymentBranchPolicy: &github.BranchPolicy{
+			CustomBranchPolicies: &t,
+			ProtectedBranches:    &f,
+		},
+		PreventSelfReview: &preventSelfReview,
+	}, nil
+}
+
+// environmentRules holds the current protection rules of an environment.
+type environmentRules struct {
+	waitTimer         int
+	preventSelfReview *bool
+
+	// reviewers holds the type and ID of each required reviewer, and reviewerNames
+	// the same reviewers in the form used by config.Reviewer.String.
+	reviewers     []*github.EnvReviewers
+	reviewerNames []string
+}
+
+// protectionRules returns the current protection rules of an environment.
+func protectionRules(env *github.Environment) environmentRules {
+	rules := environmentRules{reviewers: []*github.EnvReviewers{}, reviewerNames: []string{}}
+
+	for _, rule := range env.ProtectionRules {
+		switch rule.GetType() {
+		case "wait_timer":
+			rules.waitTimer = rule.GetWaitTimer()
+		case "required_reviewers":
+			rules.preventSelfReview = rule.PreventSelfReview
+			for _, r := range rule.Reviewers {
+				switch reviewer := r.Reviewer.(type) {
+				case *github.User:
+					rules.reviewers = append(rules.reviewers, &github.EnvReviewers{Type: github.String("User"), ID: reviewer.ID})
+					rules.reviewerNames = append(rules.reviewerNames, strings.ToLower(config.ReviewerUser+"/"+reviewer.GetLogin()))
+				case *github.Team:
+					rules.reviewers = append(rules.reviewers, &github.EnvReviewers{Type: github.String("Team"), ID: reviewer.ID})
+					rules.reviewerNames = append(rules.reviewerNames, strings.ToLower(config.ReviewerTeam+"/"+reviewer.GetSlug()))
+				}
+			}
+		}
+	}
+
+	return rules
+}
+
+// lookupReviewer returns the type and ID of a user or team that can review deployments.
+func (rc *RepoClient) lookupReviewer(ctx context.Context, reviewer config.Reviewer) (*github.EnvReviewers, error) {
+	switch reviewer.Type {
+	case config.ReviewerUser, "":
+		user, _, err := rc.client.Users.Get(ctx, reviewer.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reviewer '%s': %w", reviewer, err)
+		}
+		return &github.EnvReviewers{Type: github.String("User"), ID: user.ID}, nil
+	case config.ReviewerTeam:
+		team, _, err := rc.client.Teams.GetTeamBySlug(ctx, rc.org, reviewer.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reviewer '%s': %w", reviewer, err)
+		}
+		return &github.EnvReviewers{Type: github.String("Team"), ID: team.ID}, nil
+	default:
+		return nil, fmt.Errorf("invalid type '%s' for reviewer '%s', must be '%s' or '%s'", reviewer.Type, reviewer.Name, config.ReviewerUser, config.ReviewerTeam)
+	}
+}
+
+// reconcileEnvironment compares an existing environment with its policy and the branches
+// of its track, logging and correcting any drift.
+func (rc *RepoClient) reconcileEnvironment(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy, env *github.Environment) error {
+	fullName := fmt.Sprintf("%s/%s", rc.org, repo)
+
+	drift := environmentDrift(env, policy)
+	for _, d := range drift {
+		slog.Warn("environment drift", "repo", fullName, "environment", track.Environment, "setting", d.setting, "want", d.want, "got", d.got)
+	}
+
+	if len(drift) > 0 {
+		args, err := rc.environmentArgs(ctx, policy, env)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = rc.client.Repositories.CreateUpdateEnvironment(ctx, rc.org, repo, track.Environment, args)
+		if err != nil {
+			return fmt.Errorf("failed to update environment: %w", err)
+		}
+
+		slog.Info("environment policy updated", "repo", fullName, "environment", track.Environment)
+	}
+
+	return rc.reconcileDeploymentBranchPolicies(ctx, repo, track)
+}
+
+// reconcileDeploymentBranchPolicies ensures that the only branches permitted to deploy to
+// the environment of a track are those returned by EnvironmentBranches.
+func (rc *RepoClient) reconcileDeploymentBranchPolicies(ctx context.Context, repo string, track config.Track) error {
+	fullName := fmt.Sprintf("%s/%s", rc.org, repo)
+	branches := EnvironmentBranches(track)
+
+	existing, _, err := rc.client.Repositories.ListDeploymentBranchPolicies(ctx, rc.org, repo, track.Environment)
+	if err != nil {
+		return fmt.Errorf("failed to list branch policies: %w", err)
+	}
+
+	found := []string{}
+	for _, p := range existing.BranchPolicies {
+		name := p.GetName()
+		if slices.Contains(branches, name) {
+			found = append(found, name)
+			continue
+		}
+
+		slog.Warn("environment drift", "repo", fullName, "environment", track.Environment, "setting", "deployment_branch", "want", nil, "got", name)
+
+		_, err := rc.client.Repositories.DeleteDeploymentBranchPolicy(ctx, rc.org, repo, track.Environment, p.GetID())
+		if err != nil {
+			return fmt.Errorf("failed to delete branch policy for environment '%s', branch '%s': %w", track.Environment, name, err)
+		}
+	}
+
+	for _, branch := range branches {
+		if slices.Contains(found, branch) {
+			continue
+		}
+
+		slog.Warn("environment drift", "repo", fullName, "environment", track.Environment, "setting", "deployment_branch", "want", branch, "got", nil)
+
+		err := rc.createDeploymentBranchPolicy(ctx, repo, track.Environment, branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// environmentDrift returns the settings of an environment that differ from its policy.
+// Only the settings that the policy sets are compared, apart from the deployment branch
+// policy, which is always managed.
+func environmentDrift(env *github.Environment, policy config.EnvironmentPolicy) []policyDrift {
+	drift := []policyDrift{}
+
+	if policy.CanAdminsBypass != nil && env.CanAdminsBypass != nil && *env.CanAdminsBypass != policy.AdminsCanBypass() {
+		drift = append(drift, policyDrift{setting: "can_admins_bypass", want: policy.AdminsCanBypass(), got: *env.CanAdminsBypass})
+	}
+
+	branchPolicy := env.GetDeploymentBranchPolicy()
+	if branchPolicy == nil || !branchPolicy.GetCustomBranchPolicies() || branchPolicy.GetProtectedBranches() {
+		drift = append(drift, policyDrift{setting: "deployment_branch_policy", want: "custom", got: describeBranchPolicy(branchPolicy)})
+	}
+
+	rules := protectionRules(env)
+
+	if policy.WaitTimer != nil && rules.waitTimer != policy.WaitMinutes() {
+		drift = append(drift, policyDrift{setting: "wait_timer", want: policy.WaitMinutes(), got: rules.waitTimer})
+	}
+
+	if policy.Reviewers != nil {
+		wantReviewers := []string{}
+		for _, r := range policy.RequiredReviewers() {
+			wantReviewers = append(wantReviewers, r.String())
+		}
+
+		reviewers := slices.Clone(rules.reviewerNames)
+		slices.Sort(reviewers)
+		slices.Sort(wantReviewers)
+		if !slices.Equal(reviewers, wantReviewers) {
+			drift = append(drift, policyDrift{setting: "reviewers", want: strings.Join(wantReviewers, ","), got: strings.Join(reviewers, ",")})
+		}
+	}
+
+	// Self review only applies when reviewers are required, so is only compared if the
+	// environment has them.
+	if policy.PreventSelfReview != nil && rules.preventSelfReview != nil && *rules.preventSelfReview != policy.SelfReviewPrevented() {
+		drift = append(drift, policyDrift{setting: "prevent_self_review", want: policy.SelfReviewPrevented(), got: *rules.preventSelfReview})
+	}
+
+	return drift
+}
+
+// describeBranchPolicy returns a short description of the branches permitted to deploy
+// to an environment.
+func describeBranchPolicy(policy *github.BranchPolicy) string {
+	switch {
+	case policy == nil:
+		return "all"
+	case policy.GetProtectedBranches():
+		return "protected"
+	case policy.GetCustomBranchPolicies():
+		return "custom"
+	default:
+		return "all"
+	}
+}
+
+// isReconciled reports whether the policy of the specified environment has already been
+// reconciled during this run.
+func (rc *RepoClient) isReconciled(repo, environment string) bool {
+	rc.reconciledMu.Lock()
+	defer rc.reconciledMu.Unlock()
+
+	return rc.reconciled[repo+"/"+environment]
+}
+
+// markReconciled records that the policy of the specified environment is up to date.
+func (rc *RepoClient) markReconciled(repo, environment string) {
+	rc.reconciledMu.Lock()
+	defer rc.reconciledMu.Unlock()
+
+	rc.reconciled[repo+"/"+environment] = true
+}
@@ -0,0 +1,319 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+	"github.com/snapcrafters/tokenator/internal/config"
+)
+
+// fakeGithub is a minimal stand-in for the Github API, serving the users, teams and
+// deployment branch policies needed to reconcile environments, and recording the
+// requests that change them.
+type fakeGithub struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	policies []*github.DeploymentBranchPolicy
+}
+
+func newFakeGithub(t *testing.T, policies []*github.DeploymentBranchPolicy) *fakeGithub {
+	fg := &fakeGithub{policies: policies}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/alice", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(github.User{ID: github.Int64(1), Login: github.String("alice")})
+	})
+	mux.HandleFunc("/orgs/snapcrafters/teams/core", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(github.Team{ID: github.Int64(2), Slug: github.String("core")})
+	})
+	mux.HandleFunc("/repos/snapcrafters/gimp/environments/candidate", func(w http.ResponseWriter, r *http.Request) {
+		fg.record(r)
+		json.NewEncoder(w).Encode(github.Environment{})
+	})
+	mux.HandleFunc("/repos/snapcrafters/gimp/environments/candidate/deployment-branch-policies", func(w http.ResponseWriter, r *http.Request) {
+		fg.mu.Lock()
+		defer fg.mu.Unlock()
+
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(github.DeploymentBranchPolicyResponse{
+				TotalCount:     github.Int(len(fg.policies)),
+				BranchPolicies: fg.policies,
+			})
+			return
+		}
+
+		var body github.DeploymentBranchPolicyRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		fg.requests = append(fg.requests, r.Method+" "+body.GetName())
+		json.NewEncoder(w).Encode(github.DeploymentBranchPolicy{Name: body.Name})
+	})
+	mux.HandleFunc("/repos/snapcrafters/gimp/environments/candidate/deployment-branch-policies/", func(w http.ResponseWriter, r *http.Request) {
+		fg.record(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	fg.server = httptest.NewServer(mux)
+	t.Cleanup(fg.server.Close)
+
+	return fg
+}
+
+// client returns a RepoClient configured to use the fake.
+func (fg *fakeGithub) client() *RepoClient {
+	client := github.NewClient(fg.server.Client())
+	client.BaseURL, _ = url.Parse(fg.server.URL + "/")
+
+	return &RepoClient{client: client, org: "snapcrafters", reconciled: map[string]bool{}}
+}
+
+// record records a request that changes the environment.
+func (fg *fakeGithub) record(r *http.Request) {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+
+	fg.requests = append(fg.requests, r.Method+" "+r.URL.Path)
+}
+
+// existingEnvironment returns an environment that only permits custom branches to deploy,
+// with a 30 minute wait, and alice as a required reviewer who can't review their own
+// deployments.
+func existingEnvironment() *github.Environment {
+	return &github.Environment{
+		CanAdminsBypass: github.Bool(false),
+		DeploymentBranchPolicy: &github.BranchPolicy{
+			CustomBranchPolicies: github.Bool(true),
+			ProtectedBranches:    github.Bool(false),
+		},
+		ProtectionRules: []*github.ProtectionRule{
+			{Type: github.String("wait_timer"), WaitTimer: github.Int(30)},
+			{
+				Type:              github.String("required_reviewers"),
+				PreventSelfReview: github.Bool(true),
+				Reviewers: []*github.RequiredReviewer{
+					{Type: github.String("User"), Reviewer: &github.User{ID: github.Int64(1), Login: github.String("Alice")}},
+				},
+			},
+		},
+	}
+}
+
+func TestProtectionRules(t *testing.T) {
+	rules := protectionRules(existingEnvironment())
+
+	if rules.waitTimer != 30 {
+		t.Errorf("expected a 30 minute wait timer, got %d", rules.waitTimer)
+	}
+
+	if rules.preventSelfReview == nil || !*rules.preventSelfReview {
+		t.Errorf("expected self review to be prevented")
+	}
+
+	if len(rules.reviewers) != 1 || rules.reviewers[0].GetType() != "User" || rules.reviewers[0].GetID() != 1 {
+		t.Errorf("expected user 1 to be a reviewer, got %v", rules.reviewers)
+	}
+
+	if !slices.Equal(rules.reviewerNames, []string{"user/alice"}) {
+		t.Errorf("expected reviewer names [user/alice], got %v", rules.reviewerNames)
+	}
+
+	rules = protectionRules(&github.Environment{})
+	if rules.waitTimer != 0 || rules.preventSelfReview != nil || len(rules.reviewers) != 0 {
+		t.Errorf("expected no protection rules, got %+v", rules)
+	}
+}
+
+func TestEnvironmentArgsKeepsExistingSettings(t *testing.T) {
+	fg := newFakeGithub(t, nil)
+
+	// The policy sets nothing, so every setting is kept from the existing environment,
+	// without looking up any reviewers.
+	args, err := fg.client().environmentArgs(context.Background(), config.EnvironmentPolicy{}, existingEnvironment())
+	if err != nil {
+		t.Fatalf("environmentArgs returned error: %v", err)
+	}
+
+	if args.GetCanAdminsBypass() {
+		t.Errorf("expected admins to be kept from bypassing the environment")
+	}
+	if args.GetWaitTimer() != 30 {
+		t.Errorf("expected the 30 minute wait timer to be kept, got %d", args.GetWaitTimer())
+	}
+	if !args.GetPreventSelfReview() {
+		t.Errorf("expected self review to be kept prevented")
+	}
+	if len(args.Reviewers) != 1 || args.Reviewers[0].GetID() != 1 {
+		t.Errorf("expected alice to be kept as a reviewer, got %v", args.Reviewers)
+	}
+}
+
+func TestEnvironmentArgsResolvesReviewers(t *testing.T) {
+	fg := newFakeGithub(t, nil)
+
+	wait := 5
+	policy := config.EnvironmentPolicy{
+		Reviewers: &[]config.Reviewer{{Name: "alice"}, {Type: config.ReviewerTeam, Name: "core"}},
+		WaitTimer: &wait,
+	}
+
+	args, err := fg.client().environmentArgs(context.Background(), policy, existingEnvironment())
+	if err != nil {
+		t.Fatalf("environmentArgs returned error: %v", err)
+	}
+
+	if len(args.Reviewers) != 2 {
+		t.Fatalf("expected 2 reviewers, got %v", args.Reviewers)
+	}
+	if args.Reviewers[0].GetType() != "User" || args.Reviewers[0].GetID() != 1 {
+		t.Errorf("expected alice to be resolved to user 1, got %v", args.Reviewers[0])
+	}
+	if args.Reviewers[1].GetType() != "Team" || args.Reviewers[1].GetID() != 2 {
+		t.Errorf("expected core to be resolved to team 2, got %v", args.Reviewers[1])
+	}
+
+	if args.GetWaitTimer() != 5 {
+		t.Errorf("expected the wait timer to be set from the policy, got %d", args.GetWaitTimer())
+	}
+
+	// Settings the policy doesn't set are still kept from the existing environment.
+	if !args.GetPreventSelfReview() {
+		t.Errorf("expected self review to be kept prevented")
+	}
+}
+
+func TestEnvironmentArgsUnknownReviewer(t *testing.T) {
+	fg := newFakeGithub(t, nil)
+
+	policy := config.EnvironmentPolicy{Reviewers: &[]config.Reviewer{{Name: "bob"}}}
+
+	_, err := fg.client().environmentArgs(context.Background(), policy, nil)
+	if err == nil {
+		t.Errorf("expected an error for a reviewer that doesn't exist")
+	}
+}
+
+func TestEnvironmentDrift(t *testing.T) {
+	yes, no := true, false
+	wait := 30
+	noWait := 0
+
+	tests := []struct {
+		name     string
+		env      func(env *github.Environment)
+		policy   config.EnvironmentPolicy
+		expected []string
+	}{
+		{
+			name:     "policy sets nothing",
+			expected: []string{},
+		},
+		{
+			name:     "policy matches",
+			policy:   config.EnvironmentPolicy{CanAdminsBypass: &no, WaitTimer: &wait, PreventSelfReview: &yes, Reviewers: &[]config.Reviewer{{Name: "alice"}}},
+			expected: []string{},
+		},
+		{
+			name:     "admins can bypass",
+			policy:   config.EnvironmentPolicy{CanAdminsBypass: &yes},
+			expected: []string{"can_admins_bypass"},
+		},
+		{
+			name:     "wait timer",
+			policy:   config.EnvironmentPolicy{WaitTimer: &noWait},
+			expected: []string{"wait_timer"},
+		},
+		{
+			name:     "reviewers",
+			policy:   config.EnvironmentPolicy{Reviewers: &[]config.Reviewer{{Name: "alice"}, {Type: config.ReviewerTeam, Name: "core"}}},
+			expected: []string{"reviewers"},
+		},
+		{
+			name:     "self review",
+			policy:   config.EnvironmentPolicy{PreventSelfReview: &no},
+			expected: []string{"prevent_self_review"},
+		},
+		{
+			name:     "all branches can deploy",
+			env:      func(env *github.Environment) { env.DeploymentBranchPolicy = nil },
+			expected: []string{"deployment_branch_policy"},
+		},
+		{
+			name: "protected branches can deploy",
+			env: func(env *github.Environment) {
+				env.DeploymentBranchPolicy = &github.BranchPolicy{ProtectedBranches: &yes, CustomBranchPolicies: &no}
+			},
+			expected: []string{"deployment_branch_policy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := existingEnvironment()
+			if tt.env != nil {
+				tt.env(env)
+			}
+
+			settings := []string{}
+			for _, d := range environmentDrift(env, tt.policy) {
+				settings = append(settings, d.setting)
+			}
+
+			if !slices.Equal(settings, tt.expected) {
+				t.Errorf("expected drift in %v, got %v", tt.expected, settings)
+			}
+		})
+	}
+}
+
+func TestReconcileEnvironmentRemovesUndeclaredBranches(t *testing.T) {
+	fg := newFakeGithub(t, []*github.DeploymentBranchPolicy{
+		{ID: github.Int64(1), Name: github.String("candidate")},
+		{ID: github.Int64(2), Name: github.String("feature")},
+	})
+
+	track := config.Track{Name: "latest", Branch: "stable", Environment: "candidate"}
+
+	err := fg.client().reconcileEnvironment(context.Background(), "gimp", track, config.EnvironmentPolicy{}, existingEnvironment())
+	if err != nil {
+		t.Fatalf("reconcileEnvironment returned error: %v", err)
+	}
+
+	// The environment has no drift, so only its branch policies are changed: the branch
+	// of another track is removed, and the track's own branch is added.
+	expected := []string{
+		"DELETE /repos/snapcrafters/gimp/environments/candidate/deployment-branch-policies/2",
+		"POST stable",
+	}
+	if !slices.Equal(fg.requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, fg.requests)
+	}
+}
+
+func TestReconcileEnvironmentCorrectsDrift(t *testing.T) {
+	fg := newFakeGithub(t, []*github.DeploymentBranchPolicy{
+		{ID: github.Int64(1), Name: github.String("candidate")},
+		{ID: github.Int64(2), Name: github.String("stable")},
+	})
+
+	track := config.Track{Name: "latest", Branch: "stable", Environment: "candidate"}
+	noWait := 0
+
+	err := fg.client().reconcileEnvironment(context.Background(), "gimp", track, config.EnvironmentPolicy{WaitTimer: &noWait}, existingEnvironment())
+	if err != nil {
+		t.Fatalf("reconcileEnvironment returned error: %v", err)
+	}
+
+	// The environment is updated, and its branch policies are left as they are.
+	expected := []string{"PUT /repos/snapcrafters/gimp/environments/candidate"}
+	if !slices.Equal(fg.requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, fg.requests)
+	}
+}
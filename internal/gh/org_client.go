@@ -7,18 +7,23 @@ import (
 	"io"
 	"net/http"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/v58/github"
 	"github.com/snapcrafters/tokenator/internal/config"
 )
 
-// OrgClient is used for making administrative changes to a given Github org.
+// OrgClient is used for making administrative changes to a given Github org. It is
+// safe for concurrent use.
 type OrgClient struct {
 	githubClient *github.Client
 	org          string
 	credentials  config.GithubAppCredentials
 	token        string
+
+	// mu guards the lazy initialisation of githubClient and token.
+	mu sync.Mutex
 }
 
 // NewOrgClient constructs a new OrgClient using the supplied credentials.
@@ -32,6 +37,25 @@ func NewOrgClient(credentials config.GithubAppCredentials, org string) *OrgClien
 
 // ApprovePATRequest approves a waiting request for access for a token for a specific snap.
 func (oc *OrgClient) ApprovePATRequest(ctx context.Context, repo string) error {
+	err := oc.reviewPATRequest(ctx, repo, "approve")
+	if err != nil {
+		return fmt.Errorf("failed to approve personal access token request: %w", err)
+	}
+	return nil
+}
+
+// DenyPATRequest denies a waiting request for access for a token for a specific snap.
+func (oc *OrgClient) DenyPATRequest(ctx context.Context, repo string) error {
+	err := oc.reviewPATRequest(ctx, repo, "deny")
+	if err != nil {
+		return fmt.Errorf("failed to deny personal access token request: %w", err)
+	}
+	return nil
+}
+
+// reviewPATRequest finds the waiting request for access for a token for a specific snap,
+// and either approves or denies it according to the action.
+func (oc *OrgClient) reviewPATRequest(ctx context.Context, repo string, action string) error {
 	client, err := oc.client()
 	if err != nil {
 		return fmt.Errorf("unable to get org client: %w", err)
@@ -42,11 +66,11 @@ func (oc *OrgClient) ApprovePATRequest(ctx context.Context, repo string) error {
 		return fmt.Errorf("could not find PAT request for %s/%s", oc.org, repo)
 	}
 
-	opts := github.ReviewPersonalAccessTokenRequestOptions{Action: "approve"}
+	opts := github.ReviewPersonalAccessTokenRequestOptions{Action: action}
 
 	_, err = client.Organizations.ReviewPersonalAccessTokenRequest(ctx, oc.org, requestId, opts)
 	if err != nil {
-		return fmt.Errorf("failed to approve personal access token request: %w", err)
+		return fmt.Errorf("failed to review personal access token request: %w", err)
 	}
 
 	return nil
@@ -55,6 +79,9 @@ func (oc *OrgClient) ApprovePATRequest(ctx context.Context, repo string) error {
 // client returns an authenticated Github client, generating an access token from
 // the app credentials if the client hasn't previously been logged in.
 func (oc *OrgClient) client() (*github.Client, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
 	// Check if the client has already been initialised and just return it if it has.
 	if oc.githubClient != nil {
 		return oc.githubClient, nil
@@ -67,7 +94,8 @@ func (oc *OrgClient) client() (*github.Client, error) {
 	}
 
 	oc.token = token
-	return github.NewClient(nil).WithAuthToken(token), nil
+	oc.githubClient = github.NewClient(nil).WithAuthToken(token)
+	return oc.githubClient, nil
 }
 
 // findPATRequest is used to find the ID of the latest PAT request for a given repo.
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -46,13 +47,33 @@ func (p *PAT) Delete(pc *PATClient) error {
 	return nil
 }
 
+// DefaultPATExpiry is the lifetime of Personal Access Tokens created by the PATClient,
+// unless otherwise specified.
+const DefaultPATExpiry = 366 * 24 * time.Hour
+
+// MaxPATExpiry is the longest lifetime Github permits for a Personal Access Token.
+const MaxPATExpiry = 366 * 24 * time.Hour
+
+// ValidatePATExpiry ensures the specified lifetime is within the limits Github allows.
+// Github only accepts an expiry as a whole number of days.
+func ValidatePATExpiry(expiry time.Duration) error {
+	if expiry%(24*time.Hour) != 0 || expiry < 24*time.Hour || expiry > MaxPATExpiry {
+		return fmt.Errorf("personal access token expiry must be a whole number of days between 1 and %d", int(MaxPATExpiry.Hours()/24))
+	}
+	return nil
+}
+
 // PATClient represents an http.Client that can be logged into Github, retaining any
-// session cookies, and used for listing, creating, and deleting PATs.
+// session cookies, and used for listing, creating, and deleting PATs. It is safe for
+// concurrent use.
 type PATClient struct {
 	username   string
 	password   string
 	totpSecret string
 	c          *http.Client
+
+	// loginMu ensures only one goroutine walks through the login flow at a time.
+	loginMu sync.Mutex
 }
 
 // NewPATClient constructs a new PATClient and returns it.
@@ -90,6 +111,7 @@ func (pc *PATClient) List(filter string) ([]*PAT, error) {
 
 	// Create a wait group so we can easily process the remaining pages concurrently
 	errs := errgroup.Group{}
+	mu := sync.Mutex{}
 
 	// Iterate through the pages, collecting the access tokens
 	for i := 2; i < pageCount+1; i++ {
@@ -101,6 +123,9 @@ func (pc *PATClient) List(filter string) ([]*PAT, error) {
 				return fmt.Errorf("failed to parse personal access tokens page %d", j)
 			}
 
+			mu.Lock()
+			defer mu.Unlock()
+
 			accessTokens = append(accessTokens, pc.parsePATListPage(doc, filter)...)
 			return nil
 		})
@@ -116,8 +141,13 @@ func (pc *PATClient) List(filter string) ([]*PAT, error) {
 
 // Create adds a new PAT to the logged in account scoped to the specified repos.
 // At present the scope cannot be modified, and gives metadata read access, and
-// contents read/write access. Token expiry defaults to now + 1 year.
-func (pc *PATClient) Create(name string, repos []string, resourceOwner string) (*PAT, error) {
+// contents read/write access. The token expires after the specified duration.
+func (pc *PATClient) Create(name string, repos []string, resourceOwner string, expiry time.Duration) (*PAT, error) {
+	err := ValidatePATExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+
 	if ok, err := pc.login(); !ok {
 		return nil, fmt.Errorf("%w", err)
 	}
@@ -148,7 +178,7 @@ func (pc *PATClient) Create(name string, repos []string, resourceOwner string) (
 	fields.Set("authenticity_token", createToken)
 	fields.Set("confirm", "1")
 	fields.Set("user_programmatic_access[name]", name)
-	fields.Set("user_programmatic_access[default_expires_at]", "366")
+	fields.Set("user_programmatic_access[default_expires_at]", strconv.Itoa(int(expiry.Hours()/24)))
 	fields.Set("user_programmatic_access[description]", "")
 	fields.Set("target_name", resourceOwner)
 	fields.Set("install_target", "selected")
@@ -212,6 +242,9 @@ func (pc *PATClient) checkLoggedIn() bool {
 // login is a helper method that returns early if the http client already holds a
 // valid logged in session, or otherwise walks through the Github login flow.
 func (pc *PATClient) login() (bool, error) {
+	pc.loginMu.Lock()
+	defer pc.loginMu.Unlock()
+
 	if pc.checkLoggedIn() {
 		return true, nil
 	}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v58/github"
 	"github.com/snapcrafters/tokenator/internal/config"
@@ -16,35 +19,56 @@ import (
 type RepoClient struct {
 	client *github.Client
 	org    string
+
+	// reconciled records the environments whose policy has been reconciled during
+	// this run, so that each is only checked once.
+	reconciled   map[string]bool
+	reconciledMu sync.Mutex
 }
 
 // NewRepoClient constructs a new RepoClient with the specified credentials.
 func NewRepoClient(token string, org string) *RepoClient {
 	return &RepoClient{
-		client: github.NewClient(nil).WithAuthToken(token),
-		org:    org,
+		client:     github.NewClient(nil).WithAuthToken(token),
+		org:        org,
+		reconciled: map[string]bool{},
 	}
 }
 
 // SetEnvSecret sets a secret in the specified environment for the specified repo. If the
-// environment does not exist, it is created.
-func (rc *RepoClient) SetEnvSecret(ctx context.Context, repo string, track config.Track, secretName, secretValue string) error {
+// environment does not exist, it is created with the specified policy, otherwise its
+// policy is reconciled. If the secret cannot be set, any environment created by the call
+// is deleted again.
+func (rc *RepoClient) SetEnvSecret(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy, secretName, secretValue string) error {
 	r, _, err := rc.client.Repositories.Get(ctx, rc.org, repo)
 	if err != nil {
 		return fmt.Errorf("failed to get repository: %w", err)
 	}
 
-	err = rc.ensureEnvironment(ctx, repo, track)
+	created, err := rc.ensureEnvironment(ctx, repo, track, policy)
 	if err != nil {
 		return fmt.Errorf("failed to get environment: %w", err)
 	}
 
-	secret, err := rc.encryptSecret(ctx, r, track.Environment, secretName, secretValue)
+	err = rc.setEnvSecret(ctx, r, track.Environment, secretName, secretValue)
+	if err != nil && created {
+		_, delErr := rc.client.Repositories.DeleteEnvironment(ctx, rc.org, repo, track.Environment)
+		if delErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to delete newly created environment: %w", delErr))
+		}
+	}
+
+	return err
+}
+
+// setEnvSecret encrypts and sets a secret in an existing environment.
+func (rc *RepoClient) setEnvSecret(ctx context.Context, repo *github.Repository, envName, secretName, secretValue string) error {
+	secret, err := rc.encryptSecret(ctx, repo, envName, secretName, secretValue)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt secret: %w", err)
 	}
 
-	_, err = rc.client.Actions.CreateOrUpdateEnvSecret(ctx, int(*r.ID), track.Environment, secret)
+	_, err = rc.client.Actions.CreateOrUpdateEnvSecret(ctx, int(*repo.ID), envName, secret)
 	if err != nil {
 		return fmt.Errorf("failed to set secret in environment: %w", err)
 	}
@@ -52,6 +76,139 @@ func (rc *RepoClient) SetEnvSecret(ctx context.Context, repo string, track confi
 	return nil
 }
 
+// EnvSecretUpdatedAt returns the time at which the specified secret was last updated in
+// the specified environment. If the environment or the secret does not exist, the zero
+// time is returned.
+func (rc *RepoClient) EnvSecretUpdatedAt(ctx context.Context, repo string, environment string, secretName string) (time.Time, error) {
+	r, _, err := rc.client.Repositories.Get(ctx, rc.org, repo)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	secret, resp, err := rc.client.Actions.GetEnvSecret(ctx, int(*r.ID), environment, secretName)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get secret from environment: %w", err)
+	}
+
+	return secret.UpdatedAt.Time, nil
+}
+
+// DeleteEnvSecret deletes a secret from the specified environment. It is not an error
+// if the repo, environment or secret does not exist.
+func (rc *RepoClient) DeleteEnvSecret(ctx context.Context, repo string, environment string, secretName string) error {
+	r, resp, err := rc.client.Repositories.Get(ctx, rc.org, repo)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	resp, err = rc.client.Actions.DeleteEnvSecret(ctx, int(*r.ID), environment, secretName)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to delete secret from environment: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteEnvironment deletes the specified environment, along with all of its secrets.
+// It is not an error if the repo or environment does not exist.
+func (rc *RepoClient) DeleteEnvironment(ctx context.Context, repo string, environment string) error {
+	resp, err := rc.client.Repositories.DeleteEnvironment(ctx, rc.org, repo, environment)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to delete environment: %w", err)
+	}
+
+	return nil
+}
+
+// EnvSecret describes a secret set in a Github environment. Secret values can't be read
+// back from Github.
+type EnvSecret struct {
+	Name      string
+	UpdatedAt time.Time
+}
+
+// ListEnvSecrets returns the secrets set in the specified environment, and reports
+// whether the environment exists.
+func (rc *RepoClient) ListEnvSecrets(ctx context.Context, repo string, environment string) ([]EnvSecret, bool, error) {
+	r, _, err := rc.client.Repositories.Get(ctx, rc.org, repo)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	secrets := []EnvSecret{}
+	opts := &github.ListOptions{PerPage: 100}
+
+	for {
+		page, resp, err := rc.client.Actions.ListEnvSecrets(ctx, int(*r.ID), environment, opts)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, false, nil
+		}
+
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to list secrets in environment: %w", err)
+		}
+
+		for _, s := range page.Secrets {
+			secrets = append(secrets, EnvSecret{Name: s.Name, UpdatedAt: s.UpdatedAt.Time})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return secrets, true, nil
+}
+
+// SetOrgSecret sets an Actions secret for the org, visible only to the specified repos.
+// Any repos previously selected, but no longer specified, lose access to the secret.
+func (rc *RepoClient) SetOrgSecret(ctx context.Context, secretName, secretValue string, repos []string) error {
+	ids := github.SelectedRepoIDs{}
+	for _, repo := range repos {
+		r, _, err := rc.client.Repositories.Get(ctx, rc.org, repo)
+		if err != nil {
+			return fmt.Errorf("failed to get repository '%s': %w", repo, err)
+		}
+		ids = append(ids, r.GetID())
+	}
+
+	key, _, err := rc.client.Actions.GetOrgPublicKey(ctx, rc.org)
+	if err != nil {
+		return fmt.Errorf("failed to get org public key: %w", err)
+	}
+
+	secret, err := sealSecret(key, secretName, secretValue)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	secret.Visibility = "selected"
+	secret.SelectedRepositoryIDs = ids
+
+	_, err = rc.client.Actions.CreateOrUpdateOrgSecret(ctx, rc.org, secret)
+	if err != nil {
+		return fmt.Errorf("failed to set org secret: %w", err)
+	}
+
+	return nil
+}
+
 // encryptSecret fetches the public key from the specified Environment, and uses it to encrypt
 // the specified secretValue such that it can be uploaded securely.
 func (rc *RepoClient) encryptSecret(ctx context.Context, repo *github.Repository, envName, secretName, secretValue string) (*github.EncryptedSecret, error) {
@@ -60,6 +217,11 @@ func (rc *RepoClient) encryptSecret(ctx context.Context, repo *github.Repository
 		return nil, fmt.Errorf("failed to get environment public key: %w", err)
 	}
 
+	return sealSecret(key, secretName, secretValue)
+}
+
+// sealSecret encrypts the specified secretValue with a public key from Github.
+func sealSecret(key *github.PublicKey, secretName, secretValue string) (*github.EncryptedSecret, error) {
 	// Decode the public key from base64
 	keyBytes, err := base64.StdEncoding.DecodeString(*key.Key)
 	if err != nil {
@@ -79,52 +241,65 @@ func (rc *RepoClient) encryptSecret(ctx context.Context, repo *github.Repository
 	}, nil
 }
 
+// EnsureEnvironment creates the environment for the specified track if it doesn't exist,
+// or otherwise reconciles its policy, logging any drift from it.
+func (rc *RepoClient) EnsureEnvironment(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy) error {
+	_, err := rc.ensureEnvironment(ctx, repo, track, policy)
+	return err
+}
+
 // ensureEnvironment attempts to fetch the specified Environment for the specified repo, and
-// creates it if it doesn't exist.
-func (rc *RepoClient) ensureEnvironment(ctx context.Context, repo string, track config.Track) error {
-	_, resp, err := rc.client.Repositories.GetEnvironment(ctx, rc.org, repo, track.Environment)
+// creates it if it doesn't exist. Existing environments have their policy reconciled, once
+// per run. It reports whether the environment was created.
+func (rc *RepoClient) ensureEnvironment(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy) (bool, error) {
+	env, resp, err := rc.client.Repositories.GetEnvironment(ctx, rc.org, repo, track.Environment)
 
-	if resp.StatusCode == http.StatusNotFound {
-		err = rc.createEnvironment(ctx, repo, track)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		err = rc.createEnvironment(ctx, repo, track, policy)
 		if err != nil {
-			return fmt.Errorf("failed to create environment: %w", err)
+			// Remove the partially configured environment, so that it's created afresh
+			// on the next run.
+			_, delErr := rc.client.Repositories.DeleteEnvironment(ctx, rc.org, repo, track.Environment)
+			if delErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to delete partially created environment: %w", delErr))
+			}
+			return false, fmt.Errorf("failed to create environment: %w", err)
 		}
-		return nil
+		rc.markReconciled(repo, track.Environment)
+		return true, nil
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to get environment: %w", err)
+		return false, fmt.Errorf("failed to get environment: %w", err)
 	}
 
-	return nil
-}
-
-// createEnvironment creates an environment for the specified repository
-func (rc *RepoClient) createEnvironment(ctx context.Context, repo string, track config.Track) error {
-	t := true
-	f := false
+	if rc.isReconciled(repo, track.Environment) {
+		return false, nil
+	}
 
-	createArgs := &github.CreateUpdateEnvironment{
-		CanAdminsBypass: &t,
-		DeploymentBranchPolicy: &github.BranchPolicy{
-			CustomBranchPolicies: &t,
-			ProtectedBranches:    &f,
-		},
-		PreventSelfReview: &t,
+	err = rc.reconcileEnvironment(ctx, repo, track, policy, env)
+	if err != nil {
+		return false, fmt.Errorf("failed to reconcile environment: %w", err)
 	}
+	rc.markReconciled(repo, track.Environment)
 
-	_, _, err := rc.client.Repositories.CreateUpdateEnvironment(ctx, rc.org, repo, track.Environment, createArgs)
+	return false, nil
+}
+
+// createEnvironment creates an environment for the specified repository
+func (rc *RepoClient) createEnvironment(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy) error {
+	createArgs, err := rc.environmentArgs(ctx, policy, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create branch policy: %w", err)
+		return err
 	}
 
-	err = rc.createDeploymentBranchPolicy(ctx, repo, track.Environment, track.Branch)
+	_, _, err = rc.client.Repositories.CreateUpdateEnvironment(ctx, rc.org, repo, track.Environment, createArgs)
 	if err != nil {
 		return fmt.Errorf("failed to create branch policy: %w", err)
 	}
 
-	if track.Branch != "candidate" {
-		err = rc.createDeploymentBranchPolicy(ctx, repo, track.Environment, "candidate")
+	for _, branch := range EnvironmentBranches(track) {
+		err = rc.createDeploymentBranchPolicy(ctx, repo, track.Environment, branch)
 		if err != nil {
 			return fmt.Errorf("failed to create branch policy: %w", err)
 		}
@@ -133,6 +308,16 @@ func (rc *RepoClient) createEnvironment(ctx context.Context, repo string, track
 	return nil
 }
 
+// EnvironmentBranches returns the list of branches permitted to deploy to the
+// environment for the specified track. The 'candidate' branch is always included.
+func EnvironmentBranches(track config.Track) []string {
+	branches := []string{track.Branch}
+	if track.Branch != "candidate" {
+		branches = append(branches, "candidate")
+	}
+	return branches
+}
+
 func (rc *RepoClient) createDeploymentBranchPolicy(ctx context.Context, repo string, env string, branch string) error {
 	b := branch
 	branchPolicyRequest := &github.DeploymentBranchPolicyRequest{Name: &b}
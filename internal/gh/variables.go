@@ -0,0 +1,94 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// SetEnvVariables reconciles the Actions variables in the specified environment with
+// those specified, creating missing variables and updating those whose value has
+// drifted. Each of the undeclared variables is deleted if it exists, while any other
+// variables are left untouched. The environment must already exist.
+func (rc *RepoClient) SetEnvVariables(ctx context.Context, repo string, environment string, variables map[string]string, undeclared []string) error {
+	fullName := fmt.Sprintf("%s/%s", rc.org, repo)
+
+	r, _, err := rc.client.Repositories.Get(ctx, rc.org, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	existing, err := rc.listEnvVariables(ctx, int(r.GetID()), environment)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range undeclared {
+		if _, ok := existing[name]; !ok {
+			continue
+		}
+
+		_, err := rc.client.Actions.DeleteEnvVariable(ctx, int(r.GetID()), environment, name)
+		if err != nil {
+			return fmt.Errorf("failed to delete variable '%s' from environment: %w", name, err)
+		}
+		slog.Info("variable deleted", "repo", fullName, "environment", environment, "variable", name)
+	}
+
+	names := []string{}
+	for name := range variables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		variable := &github.ActionsVariable{Name: name, Value: variables[name]}
+
+		value, ok := existing[name]
+		switch {
+		case !ok:
+			_, err = rc.client.Actions.CreateEnvVariable(ctx, int(r.GetID()), environment, variable)
+			if err != nil {
+				return fmt.Errorf("failed to create variable '%s' in environment: %w", name, err)
+			}
+			slog.Info("variable set", "repo", fullName, "environment", environment, "variable", name)
+
+		case value != variable.Value:
+			slog.Warn("environment drift", "repo", fullName, "environment", environment, "variable", name, "want", variable.Value, "got", value)
+
+			_, err = rc.client.Actions.UpdateEnvVariable(ctx, int(r.GetID()), environment, variable)
+			if err != nil {
+				return fmt.Errorf("failed to update variable '%s' in environment: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// listEnvVariables returns the value of each Actions variable in the specified environment.
+func (rc *RepoClient) listEnvVariables(ctx context.Context, repoID int, environment string) (map[string]string, error) {
+	variables := map[string]string{}
+	opts := &github.ListOptions{PerPage: 30}
+
+	for {
+		page, resp, err := rc.client.Actions.ListEnvVariables(ctx, repoID, environment, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list variables in environment: %w", err)
+		}
+
+		for _, v := range page.Variables {
+			variables[v.Name] = v.Value
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return variables, nil
+}
@@ -0,0 +1,139 @@
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+)
+
+// DefaultPath is the location of the state file if none is configured.
+const DefaultPath = "tokenator-state.json"
+
+// Backend is used to persist the entries in a Ledger.
+type Backend interface {
+	// Load returns all of the entries previously saved.
+	Load() ([]Entry, error)
+	// Save replaces the saved entries with those specified.
+	Save(entries []Entry) error
+}
+
+// NewBackend constructs the Backend described by the state config.
+func NewBackend(state config.State) (Backend, error) {
+	switch state.Backend {
+	case "", "file":
+		path := state.Path
+		if path == "" {
+			path = DefaultPath
+		}
+		return NewFileBackend(path), nil
+	case "memory":
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend '%s'", state.Backend)
+	}
+}
+
+// FileBackend persists ledger entries to a JSON file on the local filesystem.
+type FileBackend struct {
+	path string
+}
+
+// NewFileBackend constructs a FileBackend that reads and writes the specified path.
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{path: path}
+}
+
+// Load reads the ledger entries from the state file. If the file does not exist, an
+// empty list of entries is returned.
+func (fb *FileBackend) Load() ([]Entry, error) {
+	contents, err := os.ReadFile(fb.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	entries := []Entry{}
+	err = json.Unmarshal(contents, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Save writes the ledger entries to the state file, replacing it atomically so that
+// a failed write never leaves a truncated file behind.
+func (fb *FileBackend) Save(entries []Entry) error {
+	contents, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	dir := filepath.Dir(fb.path)
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, ".tokenator-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(contents)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	err = os.Rename(f.Name(), fb.path)
+	if err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// MemoryBackend holds ledger entries in memory only, and is useful when no state
+// should be persisted between runs.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// NewMemoryBackend constructs an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{entries: []Entry{}}
+}
+
+// Load returns the entries held in memory.
+func (mb *MemoryBackend) Load() ([]Entry, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return slices.Clone(mb.entries), nil
+}
+
+// Save replaces the entries held in memory.
+func (mb *MemoryBackend) Save(entries []Entry) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.entries = slices.Clone(entries)
+	return nil
+}
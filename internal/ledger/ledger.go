@@ -0,0 +1,164 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Entry records a single credential issued by tokenator and the place it was stored, or
+// a variable set by tokenator.
+type Entry struct {
+	Repo        string `json:"repo"`
+	Track       string `json:"track"`
+	Environment string `json:"environment"`
+	Secret      string `json:"secret"`
+
+	// Description is the description given to a store token, if the credential is one,
+	// TokenID is its session ID on the store account, and Store is the name of the store
+	// that issued it.
+	Description string `json:"description,omitempty"`
+	TokenID     string `json:"token_id,omitempty"`
+	Store       string `json:"store,omitempty"`
+
+	// Channel, Profile, Permissions and Packages record the scope of a store token, so
+	// that it can be reissued if the scope in the config changes.
+	Channel     string   `json:"channel,omitempty"`
+	Profile     string   `json:"profile,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Packages    []string `json:"packages,omitempty"`
+
+	// PATID and PATName identify the Personal Access Token, if the credential is one.
+	PATID   string `json:"pat_id,omitempty"`
+	PATName string `json:"pat_name,omitempty"`
+
+	// Fingerprint is a hash of the credential value, used to identify it without
+	// storing the value itself.
+	Fingerprint string `json:"fingerprint"`
+
+	IssuedAt time.Time `json:"issued_at"`
+
+	// ExpiresAt is the time at which the credential expires. It is the zero time for
+	// credentials that do not expire.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Variable is the name of the Actions variable, if the entry records one rather than
+	// a credential. Secret is empty for such entries, and Fingerprint is a hash of the
+	// variable's value.
+	Variable string `json:"variable,omitempty"`
+
+	// LegacyRevoked marks that the store tokens described by earlier versions of
+	// tokenator for the track, with the shared Description, were revoked at the time
+	// given by IssuedAt. Secret is empty for such entries.
+	LegacyRevoked bool `json:"legacy_revoked,omitempty"`
+
+	// Pruned marks that the secret or variable was deleted because it is no longer
+	// declared in the config, at the time given by IssuedAt. Such entries record no
+	// credential.
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+// Fingerprint returns a fingerprint for the specified credential value.
+func Fingerprint(value string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(value)))
+}
+
+// Ledger is a record of every credential issued by tokenator, persisted using a Backend.
+// It is safe for concurrent use.
+type Ledger struct {
+	mu      sync.Mutex
+	backend Backend
+	entries []Entry
+}
+
+// New constructs a Ledger, populated with the entries previously saved to the backend.
+func New(backend Backend) (*Ledger, error) {
+	entries, err := backend.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ledger: %w", err)
+	}
+
+	return &Ledger{backend: backend, entries: entries}, nil
+}
+
+// Record adds an entry to the ledger and saves the ledger to its backend.
+func (l *Ledger) Record(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, entry)
+
+	err := l.backend.Save(l.entries)
+	if err != nil {
+		return fmt.Errorf("failed to save ledger: %w", err)
+	}
+
+	return nil
+}
+
+// Entries returns all of the entries in the ledger, in the order they were recorded.
+func (l *Ledger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return slices.Clone(l.entries)
+}
+
+// History returns the entries for the specified secret in the specified repo and
+// environment, ordered from most to least recently issued.
+func (l *Ledger) History(repo, environment, secret string) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	history := []Entry{}
+	for _, e := range l.entries {
+		if e.Repo == repo && e.Environment == environment && e.Secret == secret {
+			history = append(history, e)
+		}
+	}
+
+	slices.SortStableFunc(history, func(a, b Entry) int {
+		return b.IssuedAt.Compare(a.IssuedAt)
+	})
+
+	return history
+}
+
+// Latest returns the most recently issued entry for the specified secret in the
+// specified repo and environment.
+func (l *Ledger) Latest(repo, environment, secret string) (Entry, bool) {
+	history := l.History(repo, environment, secret)
+	if len(history) == 0 {
+		return Entry{}, false
+	}
+	return history[0], true
+}
+
+// Variables returns the latest entry for each variable recorded in the specified repo
+// and environment, keyed by variable name. Variables that have since been pruned are
+// omitted.
+func (l *Ledger) Variables(repo, environment string) map[string]Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	latest := map[string]Entry{}
+	for _, e := range l.entries {
+		if e.Repo != repo || e.Environment != environment || e.Variable == "" {
+			continue
+		}
+		if prev, ok := latest[e.Variable]; !ok || !e.IssuedAt.Before(prev.IssuedAt) {
+			latest[e.Variable] = e
+		}
+	}
+
+	variables := map[string]Entry{}
+	for name, e := range latest {
+		if !e.Pruned {
+			variables[name] = e
+		}
+	}
+
+	return variables
+}
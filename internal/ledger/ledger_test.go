@@ -0,0 +1,210 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var issued = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testEntries() []Entry {
+	return []Entry{
+		{
+			Repo:        "gimp",
+			Track:       "latest",
+			Environment: "Candidate Branch",
+			Secret:      "STORE_CANDIDATE",
+			Description: "tokenator-gimp-STORE_CANDIDATE",
+			Store:       "Snap Store",
+			TokenID:     "session-2",
+			Channel:     "latest/candidate",
+			Profile:     "candidate",
+			Permissions: []string{"package_access", "package_push", "package_release"},
+			Packages:    []string{"snap/gimp"},
+			Fingerprint: Fingerprint("second"),
+			IssuedAt:    issued.Add(24 * time.Hour),
+			ExpiresAt:   issued.Add(366 * 24 * time.Hour),
+		},
+		{
+			Repo:        "gimp",
+			Track:       "latest",
+			Environment: "Candidate Branch",
+			Secret:      "STORE_CANDIDATE",
+			Description: "tokenator-gimp-STORE_CANDIDATE",
+			Store:       "Snap Store",
+			TokenID:     "session-1",
+			Fingerprint: Fingerprint("first"),
+			IssuedAt:    issued,
+			ExpiresAt:   issued.Add(365 * 24 * time.Hour),
+		},
+		{
+			Repo:        "gimp",
+			Track:       "latest",
+			Environment: "Candidate Branch",
+			Secret:      "SNAPCRAFTERS_BOT_COMMIT",
+			PATID:       "1234",
+			PATName:     "token8r-abcd-gimp-latest",
+			Fingerprint: Fingerprint("pat"),
+			IssuedAt:    issued,
+			ExpiresAt:   issued.Add(366 * 24 * time.Hour),
+		},
+		{
+			Repo:        "gimp",
+			Track:       "latest",
+			Environment: "Candidate Branch",
+			Secret:      "STORE_CANDIDATE",
+			Pruned:      true,
+			IssuedAt:    issued.Add(48 * time.Hour),
+		},
+	}
+}
+
+func TestFileBackendRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "tokenator-state.json")
+
+	l, err := New(NewFileBackend(path))
+	if err != nil {
+		t.Fatalf("New returned error for a missing state file: %v", err)
+	}
+
+	if len(l.Entries()) != 0 {
+		t.Fatalf("expected no entries for a missing state file, got %d", len(l.Entries()))
+	}
+
+	for _, e := range testEntries() {
+		err := l.Record(e)
+		if err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+
+	reloaded, err := New(NewFileBackend(path))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(reloaded.Entries(), testEntries()) {
+		t.Errorf("expected reloaded entries to match those recorded, got %+v", reloaded.Entries())
+	}
+
+	// No temporary files should be left behind by the atomic save.
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("failed to read state directory: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the state file in the state directory, got %d files", len(files))
+	}
+}
+
+func TestFileBackendInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokenator-state.json")
+
+	err := os.WriteFile(path, []byte("not json"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write state file: %v", err)
+	}
+
+	_, err = New(NewFileBackend(path))
+	if err == nil {
+		t.Errorf("expected an error for an invalid state file")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	l, err := New(NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	for _, e := range testEntries() {
+		err := l.Record(e)
+		if err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+
+	history := l.History("gimp", "Candidate Branch", "STORE_CANDIDATE")
+	if len(history) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(history))
+	}
+
+	if !history[0].Pruned {
+		t.Errorf("expected the pruned entry to be the most recent")
+	}
+	if history[1].Fingerprint != Fingerprint("second") || history[2].Fingerprint != Fingerprint("first") {
+		t.Errorf("expected entries ordered from most to least recently issued")
+	}
+
+	if len(l.History("gimp", "Stable Branch", "STORE_CANDIDATE")) != 0 {
+		t.Errorf("expected no entries for a different environment")
+	}
+}
+
+func TestLatest(t *testing.T) {
+	l, err := New(NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	_, ok := l.Latest("gimp", "Candidate Branch", "STORE_CANDIDATE")
+	if ok {
+		t.Errorf("expected no latest entry for an empty ledger")
+	}
+
+	entries := testEntries()
+	for _, e := range entries[:3] {
+		err := l.Record(e)
+		if err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+
+	latest, ok := l.Latest("gimp", "Candidate Branch", "STORE_CANDIDATE")
+	if !ok || latest.Fingerprint != Fingerprint("second") {
+		t.Errorf("expected the latest entry to be the second issued, got %+v", latest)
+	}
+
+	// Once pruned, the pruned entry is the latest, so the secret is no longer issued.
+	err = l.Record(entries[3])
+	if err != nil {
+		t.Fatalf("Record returned error: %v", err)
+	}
+
+	latest, ok = l.Latest("gimp", "Candidate Branch", "STORE_CANDIDATE")
+	if !ok || !latest.Pruned {
+		t.Errorf("expected the latest entry to be pruned, got %+v", latest)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	l, err := New(NewMemoryBackend())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	for _, e := range append(testEntries(), []Entry{
+		{Repo: "gimp", Environment: "Candidate Branch", Variable: "STORE_TRACK", Fingerprint: Fingerprint("latest"), IssuedAt: issued},
+		{Repo: "gimp", Environment: "Candidate Branch", Variable: "STORE_TRACK", Fingerprint: Fingerprint("1.0"), IssuedAt: issued.Add(24 * time.Hour)},
+		{Repo: "gimp", Environment: "Candidate Branch", Variable: "SNAPS", Fingerprint: Fingerprint("gimp"), IssuedAt: issued},
+		{Repo: "gimp", Environment: "Candidate Branch", Variable: "SNAPS", Pruned: true, IssuedAt: issued.Add(24 * time.Hour)},
+		{Repo: "gimp", Environment: "Stable Branch", Variable: "RELEASE_BRANCH", Fingerprint: Fingerprint("stable"), IssuedAt: issued},
+	}...) {
+		err := l.Record(e)
+		if err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+
+	variables := l.Variables("gimp", "Candidate Branch")
+	if len(variables) != 1 {
+		t.Fatalf("expected 1 variable, got %+v", variables)
+	}
+
+	if variables["STORE_TRACK"].Fingerprint != Fingerprint("1.0") {
+		t.Errorf("expected the latest entry for the variable, got %+v", variables["STORE_TRACK"])
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// accountSeries is the series under which the store lists the snaps on an account.
+const accountSeries = "16"
+
+// AccountSnap describes a snap that the store account owns, or is a collaborator on.
+type AccountSnap struct {
+	Name    string `json:"-"`
+	SnapID  string `json:"snap-id"`
+	Status  string `json:"status"`
+	Private bool   `json:"private"`
+}
+
+// AccountSnaps returns the snaps that the store account owns or is a collaborator on,
+// sorted by name.
+func (sc *StoreClient) AccountSnaps() ([]AccountSnap, error) {
+	if sc.authEndpoints.Account == "" {
+		return nil, fmt.Errorf("listing the packages on a %s account is not supported", sc.storeType.Name)
+	}
+
+	resp, err := sc.authorizedRequest("GET", sc.endpoints.BaseURL+sc.authEndpoints.Account, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request account endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account response body: %w", err)
+	}
+
+	var account struct {
+		Snaps map[string]map[string]AccountSnap `json:"snaps"`
+	}
+
+	err = json.Unmarshal(respBytes, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account: %w", err)
+	}
+
+	snaps := []AccountSnap{}
+	for name, snap := range account.Snaps[accountSeries] {
+		snap.Name = name
+		snaps = append(snaps, snap)
+	}
+
+	slices.SortFunc(snaps, func(a, b AccountSnap) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return snaps, nil
+}
+
+// preflightDescription is the description given to the tokens requested to check the
+// permissions of the store account.
+const preflightDescription = "tokenator-preflight"
+
+// MissingPermissions returns the permissions that the store account lacks on the
+// package, of those specified. The permissions are checked with a dry request for a
+// token, which asks the store for a root macaroon but never discharges it, so no usable
+// token is issued. If the store refuses, each permission is requested in turn to find
+// those that are missing. The store still lists each root macaroon as a token on the
+// account, so those requested are revoked once the check is done.
+func (sc *StoreClient) MissingPermissions(pkg Package, permissions []string) ([]string, error) {
+	missing, err := sc.missingPermissions(pkg, permissions)
+
+	revoked, revokeErr := sc.RevokeByDescription(preflightDescription)
+	if revokeErr != nil {
+		slog.Warn("failed to revoke tokens requested to check permissions", "package", pkg.String(), "error", revokeErr.Error())
+	} else {
+		slog.Debug("revoked tokens requested to check permissions", "package", pkg.String(), "count", len(revoked))
+	}
+
+	return missing, err
+}
+
+// missingPermissions returns the permissions that the store account lacks on the
+// package, requesting a root macaroon for the permissions together, and then for each
+// permission in turn if the store refuses.
+func (sc *StoreClient) missingPermissions(pkg Package, permissions []string) ([]string, error) {
+	err := sc.requestPermissions(pkg, permissions)
+	if err == nil {
+		return []string{}, nil
+	}
+	if !errors.Is(err, ErrNoPermission) {
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, p := range permissions {
+		err := sc.requestPermissions(pkg, []string{p})
+		switch {
+		case errors.Is(err, ErrNoPermission):
+			missing = append(missing, p)
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	// The store may refuse the permissions together, but grant each of them alone.
+	if len(missing) == 0 {
+		return nil, fmt.Errorf("store refused permissions %s on %s together", strings.Join(permissions, ","), pkg)
+	}
+
+	return missing, nil
+}
+
+// requestPermissions makes a dry request for a root macaroon with the specified
+// permissions on the package, authorized as the store account.
+func (sc *StoreClient) requestPermissions(pkg Package, permissions []string) error {
+	body := tokenRequest{
+		Permissions: permissions,
+		Description: preflightDescription,
+		TTL:         int(sessionTTL.Seconds()),
+		Packages:    []Package{pkg},
+	}
+
+	resp, err := sc.authorizedRequest("POST", sc.endpoints.BaseURL+sc.authEndpoints.Tokens, body)
+	if err != nil {
+		return fmt.Errorf("failed to check permissions on %s: %w", pkg, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
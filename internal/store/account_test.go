@@ -0,0 +1,59 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAccountSnaps(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+
+	snaps, err := fd.client().AccountSnaps()
+	if err != nil {
+		t.Fatalf("AccountSnaps returned error: %v", err)
+	}
+
+	if len(snaps) != 2 || snaps[0].Name != "gimp" || snaps[1].Name != "helm" {
+		t.Fatalf("expected snaps gimp and helm, got %+v", snaps)
+	}
+
+	if snaps[0].SnapID != "1" {
+		t.Errorf("expected gimp to have snap id 1, got %s", snaps[0].SnapID)
+	}
+}
+
+func TestMissingPermissions(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.denied = map[string][]string{"helm": {"package_release"}}
+
+	permissions := []string{"package_access", "package_push", "package_release"}
+
+	missing, err := fd.client().MissingPermissions(NewSnapPackage("gimp"), permissions)
+	if err != nil {
+		t.Fatalf("MissingPermissions returned error: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected no missing permissions on gimp, got %v", missing)
+	}
+
+	missing, err = fd.client().MissingPermissions(NewSnapPackage("helm"), permissions)
+	if err != nil {
+		t.Fatalf("MissingPermissions returned error: %v", err)
+	}
+	if !slices.Equal(missing, []string{"package_release"}) {
+		t.Errorf("expected package_release to be missing on helm, got %v", missing)
+	}
+
+	// Only the session of each client remains on the account, as the tokens requested to
+	// check the permissions are revoked.
+	for _, token := range fd.tokens {
+		if token.Description != "tokenator-session-test" {
+			t.Errorf("expected no tokens to remain besides the session, got %v", token)
+		}
+	}
+
+	// The root macaroons granted, for gimp and for each permission held on helm, are revoked.
+	if len(fd.revoked) != 3 {
+		t.Errorf("expected 3 tokens requested to check permissions to be revoked, got %v", fd.revoked)
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Risks is the list of risk levels that a channel can have, from least to most stable.
+var Risks = []string{"edge", "beta", "candidate", "stable"}
+
+// QualifyChannel returns the full name of a channel, '<track>/<risk>[/<branch>]'. The
+// channel may be specified as '<risk>', '<risk>/<branch>', '<track>/<risk>' or
+// '<track>/<risk>/<branch>', where the branch may be a pattern such as '*'. If the
+// channel doesn't name a track, the specified track is used.
+func QualifyChannel(track, channel string) (string, error) {
+	parts := strings.Split(channel, "/")
+	if slices.Contains(Risks, parts[0]) {
+		parts = append([]string{track}, parts...)
+	}
+
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return "", fmt.Errorf("invalid channel '%s'", channel)
+	}
+
+	if !slices.Contains(Risks, parts[1]) {
+		return "", fmt.Errorf("invalid risk '%s' in channel '%s', must be one of %s", parts[1], channel, strings.Join(Risks, ", "))
+	}
+
+	if len(parts) == 3 {
+		_, err := path.Match(parts[2], "")
+		if err != nil {
+			return "", fmt.Errorf("invalid branch pattern '%s' in channel '%s'", parts[2], channel)
+		}
+	}
+
+	return strings.Join(parts, "/"), nil
+}
+
+// ChannelRisk returns the risk level of a channel in any of the forms accepted by
+// QualifyChannel.
+func ChannelRisk(channel string) string {
+	for _, part := range strings.Split(channel, "/") {
+		if slices.Contains(Risks, part) {
+			return part
+		}
+	}
+	return channel
+}
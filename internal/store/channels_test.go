@@ -0,0 +1,34 @@
+package store
+
+import "testing"
+
+func TestQualifyChannel(t *testing.T) {
+	tests := map[string]string{
+		"candidate":            "latest/candidate",
+		"edge/*":               "latest/edge/*",
+		"edge/fix-*":           "latest/edge/fix-*",
+		"1.0/stable":           "1.0/stable",
+		"latest/edge/*":        "latest/edge/*",
+		"preview/beta/testing": "preview/beta/testing",
+	}
+
+	for channel, expected := range tests {
+		qualified, err := QualifyChannel("latest", channel)
+		if err != nil {
+			t.Errorf("QualifyChannel returned error for '%s': %v", channel, err)
+			continue
+		}
+		if qualified != expected {
+			t.Errorf("expected '%s' to be qualified as '%s', got '%s'", channel, expected, qualified)
+		}
+	}
+}
+
+func TestQualifyChannelInvalid(t *testing.T) {
+	for _, channel := range []string{"", "alpha", "latest/alpha", "latest/edge/a/b", "edge/", "edge/[", "latest"} {
+		_, err := QualifyChannel("latest", channel)
+		if err == nil {
+			t.Errorf("expected an error for channel '%s'", channel)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateCharmhubToken(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+
+	profile, _ := Charmhub.DefaultPermissionProfile("stable")
+	packages := NewPackages("charm", []string{"discourse-k8s"})
+
+	token, err := fd.charmhubClient().GenerateStoreToken("tokenator-discourse-k8s-latest-CHARMHUB_TOKEN_STABLE", packages, "latest", "stable", profile, DefaultTokenTTL)
+	if err != nil {
+		t.Fatalf("GenerateStoreToken returned error: %v", err)
+	}
+
+	_, err = DecodeUbuntuOneToken(token.Value)
+	if err == nil {
+		t.Errorf("expected a Charmhub token rather than an Ubuntu One token")
+	}
+
+	decoded, err := DecodeCharmhubToken(token.Value)
+	if err != nil {
+		t.Fatalf("GenerateStoreToken returned an invalid token: %v", err)
+	}
+
+	// The first exchange is for the session used to list tokens, the second for the
+	// generated token.
+	auth, _ := decoded.Authorization()
+	if auth != "Macaroon charmhub-macaroon-2" {
+		t.Errorf("expected the exchanged macaroon to be used for authorization, got '%s'", auth)
+	}
+
+	// The session used to list tokens is minted first.
+	if token.SessionID != "minted-2" {
+		t.Errorf("expected the token to be identified as session minted-2, got '%s'", token.SessionID)
+	}
+}
+
+func TestGenerateCharmhubTokenOtherAccount(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+
+	// Charmhub reports the username of the account rather than its email, so the
+	// account is only told apart by its ID.
+	fd.accounts = map[string]WhoamiAccount{"minted-2": {ID: "xyz789", Name: "User", Username: "user"}}
+
+	profile, _ := Charmhub.DefaultPermissionProfile("stable")
+	packages := NewPackages("charm", []string{"discourse-k8s"})
+
+	_, err := fd.charmhubClient().GenerateStoreToken("tokenator-discourse-k8s-latest-CHARMHUB_TOKEN_STABLE", packages, "latest", "stable", profile, DefaultTokenTTL)
+	if err == nil || !strings.Contains(err.Error(), "account is 'xyz789', expected 'abc123'") {
+		t.Fatalf("expected an account mismatch error, got %v", err)
+	}
+
+	if len(fd.revoked) != 1 || fd.revoked[0] != "minted-2" {
+		t.Errorf("expected only the unverified token to be revoked, got %v", fd.revoked)
+	}
+}
+
+func TestCharmhubListTokens(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+
+	tokens, err := fd.charmhubClient().ListTokens()
+	if err != nil {
+		t.Fatalf("ListTokens returned error: %v", err)
+	}
+
+	// The session used to list the tokens is listed alongside them.
+	if len(tokens) != 6 {
+		t.Errorf("expected 6 tokens, got %d", len(tokens))
+	}
+}
+
+func TestCharmhubRejectsUbuntuOneToken(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+
+	// Without the exchange, the Ubuntu One token is sent to Charmhub as is.
+	sc := fd.charmhubClient()
+	sc.authEndpoints.TokensStoreExchange = ""
+
+	_, err := sc.ListTokens()
+	if err == nil {
+		t.Errorf("expected Charmhub to reject an Ubuntu One token")
+	}
+}
+
+func TestRefreshCharmhubToken(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+
+	token := &CharmhubToken{Macaroon: "charmhub-macaroon-1"}
+	encoded, _ := token.Encode()
+
+	_, err := fd.charmhubClient().RefreshEncodedToken(encoded)
+	if err == nil {
+		t.Errorf("expected an error refreshing a Charmhub token")
+	}
+}
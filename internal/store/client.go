@@ -2,127 +2,404 @@ package store
 
 import (
 	"bytes"
-	"encoding/base64"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/snapcrafters/tokenator/internal/config"
 	"github.com/tidwall/gjson"
 	"gopkg.in/macaroon.v1"
 )
 
-// channelPermissions represents the set of ACLS applied to store tokens
-// depending on which channel the token is for interacting with.
-var channelPermissions map[string][]string = map[string][]string{
-	"candidate": {"package_access", "package_push", "package_update", "package_release"},
-	"stable":    {"package_access", "package_release"},
+// sessionTTL is the lifetime of the token used by the StoreClient to manage the store
+// account.
+const sessionTTL = time.Hour
+
+// maxRetries is the number of times a rate limited request to the store is retried.
+const maxRetries = 3
+
+// defaultRetryAfter is how long to wait before retrying a rate limited request, if the
+// server doesn't say.
+const defaultRetryAfter = 10 * time.Second
+
+// maxRetryAfter is the longest that the client will wait before retrying a rate
+// limited request.
+const maxRetryAfter = time.Minute
+
+// DefaultTokenTTL is the lifetime of store tokens generated by the StoreClient, unless
+// otherwise specified.
+const DefaultTokenTTL = 365 * 24 * time.Hour
+
+// MaxTokenTTL is the longest lifetime the store permits for a token.
+const MaxTokenTTL = 365 * 24 * time.Hour
+
+// ValidateTokenTTL ensures the specified lifetime is within the limits the store allows.
+func ValidateTokenTTL(ttl time.Duration) error {
+	if ttl < time.Hour || ttl > MaxTokenTTL {
+		return fmt.Errorf("store token ttl must be between 1 hour and %d days", int(MaxTokenTTL.Hours()/24))
+	}
+	return nil
 }
 
 // StoreClient is a wrapper around http.Client for logging into a Canonical store.
 type StoreClient struct {
+	storeType     StoreType
 	authEndpoints StoreAuthEndpoints
 	client        *http.Client
 	credentials   config.LoginCredentials
 	endpoints     StoreEndpoints
+
+	// session is a short-lived token used to manage the store account, obtained on
+	// first use. Its description is unique to the client, so that Logout revokes the
+	// client's own session without affecting those of other clients. discharge is the
+	// macaroon obtained from Ubuntu One when logging in for the session, and accountID
+	// is the ID of the account logged in to, once known.
+	session            Token
+	sessionDescription string
+	discharge          *macaroon.Macaroon
+	accountID          string
+	sessionMu          sync.Mutex
+}
+
+// NewSnapStoreClient constructs a new StoreClient for interacting with the snap store
+// in the specified environment.
+func NewSnapStoreClient(credentials config.LoginCredentials, env Environment) *StoreClient {
+	return NewStoreClient(SnapStore, credentials, env)
 }
 
-// NewSnapStoreClient constructs a new StoreClient for interacting with the snap store.
-func NewSnapStoreClient(credentials config.LoginCredentials) *StoreClient {
+// NewStoreClient constructs a new StoreClient for interacting with the specified store
+// in the specified environment.
+func NewStoreClient(storeType StoreType, credentials config.LoginCredentials, env Environment) *StoreClient {
 	return &StoreClient{
-		endpoints:     SNAP_STORE_ENDPOINTS,
-		authEndpoints: UBUNTU_ONE_SNAP_STORE_AUTH_ENDPOINTS,
+		storeType:     storeType,
+		endpoints:     env.Endpoints,
+		authEndpoints: env.AuthEndpoints,
 		credentials:   credentials,
 		client:        &http.Client{},
+
+		sessionDescription: newSessionDescription(),
 	}
 }
 
-// GenerateStoreToken takes a snap, track and channel and returns a token with a
-// TTL of 1 year, with default permissions for the given channel.
-func (sc *StoreClient) GenerateStoreToken(repo string, snaps []string, track, channel string) (string, error) {
-	permissions, ok := channelPermissions[channel]
-	if !ok {
-		return "", fmt.Errorf("invalid channel specified")
+// newSessionDescription returns a random description for a session token, in the form
+// 'tokenator-session-<hex>'.
+func newSessionDescription() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("tokenator-session-%x", b)
+}
+
+// StoreType returns the type of store that the client interacts with.
+func (sc *StoreClient) StoreType() StoreType {
+	return sc.storeType
+}
+
+// TokenDescription returns the description given to store tokens generated for the
+// specified secret in the specified repo and track, in the form
+// 'tokenator-<repo>-<track>-<secret>'. Each secret has its own description, so that
+// the tokens issued for one secret can't be mistaken for those of another.
+func TokenDescription(repo, track, secret string) string {
+	return fmt.Sprintf("tokenator-%s-%s-%s", repo, track, secret)
+}
+
+// LegacyTokenDescription returns the description given to store tokens for the specified
+// repo and track by earlier versions of tokenator, in the form 'tokenator-<repo>-<track>'.
+// This description was shared by the tokens of every secret in the track.
+func LegacyTokenDescription(repo, track string) string {
+	return fmt.Sprintf("tokenator-%s-%s", repo, track)
+}
+
+// GeneratedToken is a store token generated by GenerateStoreToken.
+type GeneratedToken struct {
+	// Value is the encoded token, in the form used by snapcraft and charmcraft.
+	Value string
+
+	// IssuedToken identifies the token on the store account, so that it can be revoked.
+	IssuedToken
+}
+
+// GenerateStoreToken takes a set of packages, track and channel and returns a token with
+// the specified TTL and description, with the permissions of the specified profile. The
+// channel may be a risk, or a channel branch pattern such as 'edge/*', as accepted by
+// QualifyChannel. The session ID of the token on the store account is found by comparing
+// the tokens with the same description before and after the token is generated.
+func (sc *StoreClient) GenerateStoreToken(description string, packages []Package, track, channel string, profile PermissionProfile, ttl time.Duration) (*GeneratedToken, error) {
+	err := ValidateTokenTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	qualified, err := QualifyChannel(track, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packages {
+		if !slices.Contains(sc.authEndpoints.ValidPackageTypes, p.Type) {
+			return nil, fmt.Errorf("%s does not accept packages of type '%s'", sc.storeType.Name, p.Type)
+		}
 	}
 
 	tokenParams := tokenParams{
-		Permissions: permissions,
-		Description: fmt.Sprintf("tokenator-%s-%s", repo, track),
-		TTL:         60 * 60 * 24 * 365, // 1 year
+		Permissions: profile.Permissions,
+		Description: description,
+		TTL:         int(ttl.Seconds()),
 		Credentials: sc.credentials,
-		Packages:    snaps,
-		Channels:    []string{fmt.Sprintf("%s/%s", track, channel)},
+		Packages:    packages,
+		Channels:    []string{qualified},
+	}
+
+	existing, err := sc.listTokensDescribed(description)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := sc.mint(tokenParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate store token: %w", err)
+	}
+
+	issued, err := sc.findIssuedToken(description, existing)
+	if err != nil {
+		return nil, fmt.Errorf("generated store token '%s', but could not identify it to revoke later: %w", description, err)
 	}
 
-	token, err := sc.login(tokenParams)
+	// Check the store agrees that the new token has the requested scope, revoking it
+	// if not so that a malformed token is never handed out.
+	err = sc.verifyToken(token, tokenParams)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate store token: %w", err)
+		_, revokeErr := sc.RevokeTokens([]string{issued.SessionID})
+		if revokeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to revoke unverified token: %w", revokeErr))
+		}
+		return nil, fmt.Errorf("failed to verify store token: %w", err)
 	}
 
-	return token, err
+	encoded, err := token.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate store token: %w", err)
+	}
+
+	return &GeneratedToken{Value: encoded, IssuedToken: issued}, nil
 }
 
 // login is used to login to a Canonical store and generate a scoped token
-// with access to the specified packages, at the specified permissions level.
-func (sc *StoreClient) login(params tokenParams) (string, error) {
-	tokenRequest := tokenRequest{
-		Permissions: params.Permissions,
-		Description: params.Description,
-		TTL:         params.TTL,
-		Packages:    []Package{},
-		Channels:    params.Channels,
+// with access to the specified packages, at the specified permissions level. The
+// discharged macaroon obtained from Ubuntu One is returned alongside the token.
+func (sc *StoreClient) login(params tokenParams) (Token, *macaroon.Macaroon, error) {
+	rootMacaroon, err := sc.getRootMacaroon(params.request())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get root macaroon: %w", err)
 	}
 
-	for _, p := range params.Packages {
-		tokenRequest.Packages = append(tokenRequest.Packages, NewSnapPackage(p))
+	dischargedMacaroon, err := sc.getDischargedMacaroon(rootMacaroon, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get discharged macaroon: %w", err)
 	}
 
-	rootMacaroon, err := sc.getRootMacaroon(tokenRequest)
+	token, err := sc.newToken(rootMacaroon, dischargedMacaroon)
 	if err != nil {
-		return "", fmt.Errorf("failed to get root macaroon: %w", err)
+		return nil, nil, err
 	}
 
-	dischargedMacaroon, err := sc.getDischargedMacaroon(rootMacaroon, params)
+	return token, dischargedMacaroon, nil
+}
+
+// mint generates a scoped token while logged in to the store. The root macaroon is
+// requested as the store account, and if the store issues it with the same Ubuntu One
+// caveat as the session, the session's discharged macaroon is reused rather than logging
+// in again. This avoids waiting for a fresh one-time password for every token on
+// accounts with two-factor authentication. Otherwise the caveat is discharged by logging
+// in with the account credentials.
+func (sc *StoreClient) mint(params tokenParams) (Token, error) {
+	resp, err := sc.authorizedRequest("POST", sc.endpoints.BaseURL+sc.authEndpoints.Tokens, params.request())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root macaroon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	rootMacaroon, err := sc.deserializeMacaroon(resp, "macaroon")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root macaroon: %w", err)
+	}
+
+	dischargedMacaroon := sc.sessionDischarge(rootMacaroon)
+	if dischargedMacaroon == nil {
+		dischargedMacaroon, err = sc.getDischargedMacaroon(rootMacaroon, params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get discharged macaroon: %w", err)
+		}
+	}
+
+	return sc.newToken(rootMacaroon, dischargedMacaroon)
+}
+
+// sessionDischarge returns a copy of the discharged macaroon of the session if it
+// discharges the Ubuntu One caveat of the root macaroon, or nil otherwise.
+func (sc *StoreClient) sessionDischarge(root *macaroon.Macaroon) *macaroon.Macaroon {
+	caveat, err := sc.authCaveat(root)
 	if err != nil {
-		return "", fmt.Errorf("failed to get discharged macaroon: %w", err)
+		return nil
+	}
+
+	sc.sessionMu.Lock()
+	defer sc.sessionMu.Unlock()
+
+	if sc.discharge == nil || sc.discharge.Id() != caveat.Id {
+		return nil
 	}
 
+	return sc.discharge.Clone()
+}
+
+// newToken creates a token from a root macaroon and the discharged macaroon for its
+// Ubuntu One caveat, exchanging it for a macaroon issued by the store if required.
+func (sc *StoreClient) newToken(rootMacaroon, dischargedMacaroon *macaroon.Macaroon) (Token, error) {
 	token, err := NewUbuntuOneToken(rootMacaroon, dischargedMacaroon)
 	if err != nil {
-		return "", fmt.Errorf("failed to create a valid Ubuntu One token: %w", err)
+		return nil, fmt.Errorf("failed to create a valid Ubuntu One token: %w", err)
+	}
+
+	if sc.authEndpoints.TokensStoreExchange == "" {
+		return token, nil
 	}
 
-	tokenJSON, err := json.Marshal(token)
+	exchanged, err := sc.exchangeToken(token)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Ubuntu One to JSON: %w", err)
+		return nil, fmt.Errorf("failed to exchange Ubuntu One token: %w", err)
 	}
 
-	tokenEncoded := base64.StdEncoding.EncodeToString(tokenJSON)
-	return tokenEncoded, nil
+	return exchanged, nil
+}
+
+// exchangeToken exchanges the discharged macaroons of an Ubuntu One token for a macaroon
+// issued by the store, for stores such as Charmhub that don't accept Ubuntu One tokens
+// directly.
+func (sc *StoreClient) exchangeToken(token *UbuntuOneToken) (*CharmhubToken, error) {
+	macaroons, err := token.bakeryMacaroons()
+	if err != nil {
+		return nil, err
+	}
+
+	url := sc.endpoints.BaseURL + sc.authEndpoints.TokensStoreExchange
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString("{}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct post request to url '%s': %w", url, err)
+	}
+
+	req.Header.Add("Macaroons", macaroons)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := sc.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token exchange endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token exchange response body: %w", err)
+	}
+
+	exchanged := gjson.Get(string(respBytes), "macaroon")
+	if !exchanged.Exists() || exchanged.String() == "" {
+		return nil, fmt.Errorf("no macaroon found in response json field 'macaroon'")
+	}
+
+	return &CharmhubToken{Macaroon: exchanged.String()}, nil
+}
+
+// authorization returns the value of an Authorization header that can be used to
+// manage the store account. A short-lived session token is generated on first use.
+func (sc *StoreClient) authorization() (string, error) {
+	sc.sessionMu.Lock()
+	defer sc.sessionMu.Unlock()
+
+	return sc.sessionAuthorization()
+}
+
+// sessionAuthorization returns the Authorization header for the session token, logging
+// in to obtain it if necessary. It must be called with sessionMu held.
+func (sc *StoreClient) sessionAuthorization() (string, error) {
+	if sc.session == nil {
+		session, discharge, err := sc.login(tokenParams{
+			Permissions: sc.storeType.SessionPermissions,
+			Description: sc.sessionDescription,
+			TTL:         int(sessionTTL.Seconds()),
+			Credentials: sc.credentials,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to login to store: %w", err)
+		}
+		sc.session, sc.discharge = session, discharge
+	}
+
+	return sc.session.Authorization()
+}
+
+// Logout revokes the session token used to manage the store account, if one has been
+// obtained, so that it can't be used once the client is no longer needed.
+func (sc *StoreClient) Logout() error {
+	sc.sessionMu.Lock()
+	loggedIn := sc.session != nil
+	sc.sessionMu.Unlock()
+
+	if !loggedIn {
+		return nil
+	}
+
+	_, err := sc.RevokeByDescription(sc.sessionDescription)
+	if err != nil {
+		return fmt.Errorf("failed to revoke store session: %w", err)
+	}
+
+	sc.sessionMu.Lock()
+	sc.session, sc.discharge = nil, nil
+	sc.sessionMu.Unlock()
+
+	return nil
 }
 
 // getDischargedMacaroon is a helper function that returns a discharged macaroon from the
 // store, given a root macaroon and some credentials.
 func (sc *StoreClient) getDischargedMacaroon(root *macaroon.Macaroon, params tokenParams) (*macaroon.Macaroon, error) {
-	u, _ := url.Parse(sc.authEndpoints.AuthURL)
-
-	idx := slices.IndexFunc(root.Caveats(), func(c macaroon.Caveat) bool {
-		return c.Location == u.Host
-	})
+	caveat, err := sc.authCaveat(root)
+	if err != nil {
+		return nil, err
+	}
 
 	body := macaroonDischargeParams{
 		Email:    params.Credentials.Login,
 		Password: params.Credentials.Password,
-		CaveatId: root.Caveats()[idx].Id,
+		CaveatId: caveat.Id,
+	}
+
+	// Accounts with two-factor authentication enabled must also provide a one-time password.
+	if params.Credentials.TOTPSecret != "" {
+		otp, err := otps.generate(params.Credentials.TOTPSecret)
+		if err != nil {
+			return nil, err
+		}
+		body.OTP = otp
 	}
 
 	resp, err := sc.post(sc.authEndpoints.AuthURL+sc.authEndpoints.TokensExchange, body)
+	if errors.Is(err, ErrTwoFactorRequired) && params.Credentials.TOTPSecret == "" {
+		return nil, fmt.Errorf("failed to request token exchange endpoint: %w, but no TOTP secret is configured", err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to request token exchange endpoint: %w", err)
 	}
+	defer resp.Body.Close()
 
 	dischargedMacaroon, err := sc.deserializeMacaroon(resp, "discharge_macaroon")
 	if err != nil {
@@ -132,12 +409,28 @@ func (sc *StoreClient) getDischargedMacaroon(root *macaroon.Macaroon, params tok
 	return dischargedMacaroon, nil
 }
 
+// authCaveat returns the third party caveat of the root macaroon that is discharged by
+// Ubuntu One.
+func (sc *StoreClient) authCaveat(root *macaroon.Macaroon) (macaroon.Caveat, error) {
+	u, _ := url.Parse(sc.authEndpoints.AuthURL)
+
+	idx := slices.IndexFunc(root.Caveats(), func(c macaroon.Caveat) bool {
+		return c.Location == u.Host
+	})
+	if idx < 0 {
+		return macaroon.Caveat{}, fmt.Errorf("no caveat for %s found in root macaroon", u.Host)
+	}
+
+	return root.Caveats()[idx], nil
+}
+
 // getRootMacaroon is a helper function that returns a root macaroon from the store.
 func (sc *StoreClient) getRootMacaroon(tr tokenRequest) (*macaroon.Macaroon, error) {
 	resp, err := sc.post(sc.endpoints.BaseURL+sc.authEndpoints.Tokens, tr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to request token exchange endpoint: %w", err)
 	}
+	defer resp.Body.Close()
 
 	rootMacaroon, err := sc.deserializeMacaroon(resp, "macaroon")
 	if err != nil {
@@ -157,25 +450,15 @@ func (sc *StoreClient) deserializeMacaroon(resp *http.Response, field string) (*
 
 	respMac := gjson.Get(string(respBytes), field)
 	if !respMac.Exists() {
-		return nil, fmt.Errorf("no macaroon found in response json")
-	}
-
-	decoded, err := base64.RawURLEncoding.DecodeString(respMac.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode unmarshalled macaroon")
-	}
-
-	mac := &macaroon.Macaroon{}
-	err = mac.UnmarshalBinary(decoded)
-	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize macaroon: %w", err)
+		return nil, fmt.Errorf("no macaroon found in response json field '%s'", field)
 	}
 
-	return mac, nil
+	return decodeMacaroon(respMac.String())
 }
 
 // post is a helper function for making HTTP POST requests to the store with
-// the correct headers set.
+// the correct headers set, returning an *APIError if the response does not
+// indicate success.
 func (sc *StoreClient) post(url string, body any) (*http.Response, error) {
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
@@ -190,10 +473,84 @@ func (sc *StoreClient) post(url string, body any) (*http.Response, error) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
 
-	resp, err := sc.client.Do(req)
+	return sc.do(req)
+}
+
+// authorizedRequest makes a request to the store, authorized to manage the store
+// account, and returns an error if the response does not indicate success.
+func (sc *StoreClient) authorizedRequest(method, url string, body any) (*http.Response, error) {
+	auth, err := sc.authorization()
 	if err != nil {
-		return nil, fmt.Errorf("failed to request url '%s': %w", url, err)
+		return nil, err
+	}
+
+	resp, err := sc.request(method, url, auth, body)
+
+	// The discharged macaroon of the session may need refreshing if Ubuntu One requires
+	// the account to re-authenticate, in which case the request is retried once.
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.NeedsRefresh {
+		auth, err = sc.refreshSession(auth)
+		if err != nil {
+			return nil, err
+		}
+		return sc.request(method, url, auth, body)
 	}
 
 	return resp, err
 }
+
+// request makes a request to the store with the specified Authorization header, and
+// returns an *APIError if the response does not indicate success.
+func (sc *StoreClient) request(method, url, auth string, body any) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body to json: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonBody)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct request to url '%s': %w", url, err)
+	}
+
+	req.Header.Add("Authorization", auth)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+
+	return sc.do(req)
+}
+
+// do sends a request to the store, retrying it if the store asks the client to slow
+// down. An *APIError is returned if the response does not indicate success.
+func (sc *StoreClient) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := sc.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to request url '%s': %w", req.URL, err)
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return resp, nil
+		}
+
+		apiErr := newAPIError(resp)
+		if !errors.Is(apiErr, ErrRateLimited) || attempt >= maxRetries {
+			return nil, apiErr
+		}
+
+		wait := min(apiErr.RetryAfter, maxRetryAfter)
+		slog.Warn("rate limited by store, retrying", "url", req.URL.String(), "retry_after", wait)
+		time.Sleep(wait)
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body for url '%s': %w", req.URL, err)
+			}
+		}
+	}
+}
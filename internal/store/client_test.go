@@ -0,0 +1,160 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// totpSecret is a base32 encoded secret used to generate one-time passwords in tests.
+const totpSecret = "JBSWY3DPEHPK3PXP"
+
+// fakeClock replaces the clock used to generate one-time passwords, and by the fake
+// dashboard to check them, with one that only moves when sleeping. It returns the
+// durations slept.
+func fakeClock(t *testing.T, fd *fakeDashboard) *[]time.Duration {
+	now := time.Date(2024, 3, 1, 12, 0, 10, 0, time.UTC)
+	slept := []time.Duration{}
+
+	fd.now = func() time.Time { return now }
+
+	previous := otps
+	otps = newOTPGenerator(fd.now, func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	})
+	t.Cleanup(func() { otps = previous })
+
+	return &slept
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.totpSecret = totpSecret
+	fakeClock(t, fd)
+
+	sc := fd.client()
+	sc.credentials.TOTPSecret = totpSecret
+
+	_, _, err := sc.login(tokenParams{Description: "test", Credentials: sc.credentials})
+	if err != nil {
+		t.Fatalf("login returned error: %v", err)
+	}
+}
+
+func TestLoginTwoFactorRequired(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.totpSecret = totpSecret
+
+	sc := fd.client()
+
+	_, _, err := sc.login(tokenParams{Description: "test", Credentials: sc.credentials})
+	if !errors.Is(err, ErrTwoFactorRequired) {
+		t.Fatalf("expected ErrTwoFactorRequired, got %v", err)
+	}
+}
+
+func TestLoginTwoFactorReused(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.totpSecret = totpSecret
+	fakeClock(t, fd)
+
+	sc := fd.client()
+	sc.credentials.TOTPSecret = totpSecret
+
+	_, _, err := sc.login(tokenParams{Description: "first", Credentials: sc.credentials})
+	if err != nil {
+		t.Fatalf("login returned error: %v", err)
+	}
+
+	// Forgetting the password already generated, as when each discharge generated its
+	// own, causes the same password to be sent again within the time step.
+	otps.lastStep = map[string]int64{}
+
+	_, _, err = sc.login(tokenParams{Description: "second", Credentials: sc.credentials})
+	if !errors.Is(err, ErrTwoFactorFailed) {
+		t.Fatalf("expected ErrTwoFactorFailed for a reused password, got %v", err)
+	}
+}
+
+func TestLoginTwoFactorWaitsForNextPassword(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.totpSecret = totpSecret
+	slept := fakeClock(t, fd)
+
+	sc := fd.client()
+	sc.credentials.TOTPSecret = totpSecret
+
+	for _, description := range []string{"first", "second"} {
+		_, _, err := sc.login(tokenParams{Description: description, Credentials: sc.credentials})
+		if err != nil {
+			t.Fatalf("login returned error: %v", err)
+		}
+	}
+
+	// The clock starts 10 seconds into a time step, so the second login waits 20 seconds
+	// for the next.
+	if len(*slept) != 1 || (*slept)[0] != 20*time.Second {
+		t.Errorf("expected a single wait of 20s for the next password, got %v", *slept)
+	}
+
+	if len(fd.usedOTPs) != 2 || fd.usedOTPs[0] == fd.usedOTPs[1] {
+		t.Errorf("expected two different passwords to be used, got %v", fd.usedOTPs)
+	}
+}
+
+func TestGenerateStoreTokenReusesSession(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.totpSecret = totpSecret
+	slept := fakeClock(t, fd)
+
+	sc := fd.client()
+	sc.credentials.TOTPSecret = totpSecret
+
+	profile, _ := SnapStore.DefaultPermissionProfile("stable")
+
+	for _, name := range []string{"gimp", "helm", "vlc"} {
+		_, err := sc.GenerateStoreToken("tokenator-"+name+"-latest-SNAP_STORE_STABLE", []Package{NewSnapPackage(name)}, "latest", "stable", profile, DefaultTokenTTL)
+		if err != nil {
+			t.Fatalf("GenerateStoreToken returned error: %v", err)
+		}
+	}
+
+	// Only the session logs in, as its discharge is reused for each token.
+	if len(fd.usedOTPs) != 1 {
+		t.Errorf("expected a single one-time password to be used, got %v", fd.usedOTPs)
+	}
+
+	if len(*slept) != 0 {
+		t.Errorf("expected no wait for a fresh one-time password, got %v", *slept)
+	}
+}
+
+func TestGenerateStoreTokenDischargesNewCaveat(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	fd.totpSecret = totpSecret
+	fd.uniqueCaveats = true
+	slept := fakeClock(t, fd)
+
+	sc := fd.client()
+	sc.credentials.TOTPSecret = totpSecret
+
+	profile, _ := SnapStore.DefaultPermissionProfile("stable")
+
+	for _, name := range []string{"gimp", "helm"} {
+		_, err := sc.GenerateStoreToken("tokenator-"+name+"-latest-SNAP_STORE_STABLE", []Package{NewSnapPackage(name)}, "latest", "stable", profile, DefaultTokenTTL)
+		if err != nil {
+			t.Fatalf("GenerateStoreToken returned error: %v", err)
+		}
+	}
+
+	// The session's discharge can't be reused for a different caveat, so each token
+	// logs in with a fresh one-time password.
+	if len(fd.usedOTPs) != 3 {
+		t.Errorf("expected 3 one-time passwords to be used, got %v", fd.usedOTPs)
+	}
+
+	if len(*slept) != 2 {
+		t.Errorf("expected a wait for a fresh one-time password before each token, got %v", *slept)
+	}
+}
@@ -10,6 +10,13 @@ type StoreEndpoints struct {
 // the production Snap store.
 var SNAP_STORE_ENDPOINTS = StoreEndpoints{
 	BaseURL:    "https://dashboard.snapcraft.io",
+	StorageURL: "https://upload.apps.ubuntu.com",
+}
+
+// SNAP_STORE_STAGING_ENDPOINTS represents the set of endpoints used when interacting with
+// the staging Snap store.
+var SNAP_STORE_STAGING_ENDPOINTS = StoreEndpoints{
+	BaseURL:    "https://dashboard.staging.snapcraft.io",
 	StorageURL: "https://upload.apps.staging.ubuntu.com",
 }
 
@@ -21,7 +28,14 @@ type StoreAuthEndpoints struct {
 	Tokens            string
 	TokensExchange    string
 	TokensRefresh     string
+	TokensList        string
+	TokensRevoke      string
+	Account           string
 	ValidPackageTypes []string
+
+	// TokensStoreExchange, if set, is the endpoint used to exchange discharged Ubuntu One
+	// macaroons for a macaroon issued by the store itself, as Charmhub requires.
+	TokensStoreExchange string
 }
 
 // UBUNTU_ONE_SNAP_STORE_AUTH_ENDPOINTS represents the set of endpoints used to authenticate
@@ -33,5 +47,80 @@ var UBUNTU_ONE_SNAP_STORE_AUTH_ENDPOINTS = StoreAuthEndpoints{
 	Tokens:            "/dev/api/acl/",
 	TokensExchange:    "/api/v2/tokens/discharge",
 	TokensRefresh:     "/api/v2/tokens/refresh",
+	TokensList:        "/api/v2/tokens",
+	TokensRevoke:      "/api/v2/tokens/revoke",
+	Account:           "/dev/api/account",
+	ValidPackageTypes: []string{"snap"},
+}
+
+// UBUNTU_ONE_STAGING_SNAP_STORE_AUTH_ENDPOINTS represents the set of endpoints used to
+// authenticate against the staging Snap Store using Ubuntu One credentials.
+var UBUNTU_ONE_STAGING_SNAP_STORE_AUTH_ENDPOINTS = StoreAuthEndpoints{
+	AuthURL:           "https://login.staging.ubuntu.com",
+	Namespace:         "snap",
+	Whoami:            "/api/v2/tokens/whoami",
+	Tokens:            "/dev/api/acl/",
+	TokensExchange:    "/api/v2/tokens/discharge",
+	TokensRefresh:     "/api/v2/tokens/refresh",
+	TokensList:        "/api/v2/tokens",
+	TokensRevoke:      "/api/v2/tokens/revoke",
+	Account:           "/dev/api/account",
 	ValidPackageTypes: []string{"snap"},
 }
+
+// Names of the store environments known to tokenator.
+const (
+	EnvironmentProduction = "production"
+	EnvironmentStaging    = "staging"
+)
+
+// Environment is the complete set of endpoints used to issue and manage tokens in a
+// given store environment.
+type Environment struct {
+	Endpoints     StoreEndpoints
+	AuthEndpoints StoreAuthEndpoints
+}
+
+// CHARMHUB_ENDPOINTS represents the set of endpoints used when interacting with the
+// production Charmhub.
+var CHARMHUB_ENDPOINTS = StoreEndpoints{
+	BaseURL:    "https://api.charmhub.io",
+	StorageURL: "https://storage.snapcraftcontent.com",
+}
+
+// CHARMHUB_STAGING_ENDPOINTS represents the set of endpoints used when interacting with
+// the staging Charmhub.
+var CHARMHUB_STAGING_ENDPOINTS = StoreEndpoints{
+	BaseURL:    "https://api.staging.charmhub.io",
+	StorageURL: "https://storage.staging.snapcraftcontent.com",
+}
+
+// UBUNTU_ONE_CHARMHUB_AUTH_ENDPOINTS represents the set of endpoints used to authenticate
+// against Charmhub using Ubuntu One credentials.
+var UBUNTU_ONE_CHARMHUB_AUTH_ENDPOINTS = StoreAuthEndpoints{
+	AuthURL:             "https://login.ubuntu.com",
+	Namespace:           "charm",
+	Whoami:              "/v1/tokens/whoami",
+	Tokens:              "/v1/tokens",
+	TokensExchange:      "/api/v2/tokens/discharge",
+	TokensRefresh:       "/api/v2/tokens/refresh",
+	TokensList:          "/v1/tokens",
+	TokensRevoke:        "/v1/tokens/revoke",
+	TokensStoreExchange: "/v1/tokens/exchange",
+	ValidPackageTypes:   []string{"charm", "bundle", "rock"},
+}
+
+// UBUNTU_ONE_STAGING_CHARMHUB_AUTH_ENDPOINTS represents the set of endpoints used to
+// authenticate against the staging Charmhub using Ubuntu One credentials.
+var UBUNTU_ONE_STAGING_CHARMHUB_AUTH_ENDPOINTS = StoreAuthEndpoints{
+	AuthURL:             "https://login.staging.ubuntu.com",
+	Namespace:           "charm",
+	Whoami:              "/v1/tokens/whoami",
+	Tokens:              "/v1/tokens",
+	TokensExchange:      "/api/v2/tokens/discharge",
+	TokensRefresh:       "/api/v2/tokens/refresh",
+	TokensList:          "/v1/tokens",
+	TokensRevoke:        "/v1/tokens/revoke",
+	TokensStoreExchange: "/v1/tokens/exchange",
+	ValidPackageTypes:   []string{"charm", "bundle", "rock"},
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons that the store or Ubuntu One may reject a request. Errors returned by the
+// StoreClient wrap these where the reason is known, so they can be checked with errors.Is.
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrTwoFactorRequired  = errors.New("two-factor authentication required")
+	ErrTwoFactorFailed    = errors.New("invalid one-time password")
+	ErrAccountSuspended   = errors.New("account suspended")
+	ErrUnknownPackage     = errors.New("unknown package")
+	ErrNoPermission       = errors.New("no permission on package")
+	ErrRateLimited        = errors.New("rate limited")
+)
+
+// errorCodes maps the error codes returned by the store and Ubuntu One to the reason
+// for the error.
+var errorCodes = map[string]error{
+	// Ubuntu One
+	"INVALID_CREDENTIALS": ErrInvalidCredentials,
+	"TWOFACTOR_REQUIRED":  ErrTwoFactorRequired,
+	"TWOFACTOR_FAILURE":   ErrTwoFactorFailed,
+	"ACCOUNT_SUSPENDED":   ErrAccountSuspended,
+	"ACCOUNT_DEACTIVATED": ErrAccountSuspended,
+	"TOO_MANY_REQUESTS":   ErrRateLimited,
+
+	// Snap Store and Charmhub
+	"account-suspended":            ErrAccountSuspended,
+	"user-not-ready":               ErrAccountSuspended,
+	"resource-not-found":           ErrUnknownPackage,
+	"snap-not-found":               ErrUnknownPackage,
+	"package-not-found":            ErrUnknownPackage,
+	"macaroon-permission-required": ErrNoPermission,
+	"permission-required":          ErrNoPermission,
+	"rate-limit-exceeded":          ErrRateLimited,
+}
+
+// statusErrors maps HTTP status codes to the reason for the error, for responses that
+// don't include a known error code.
+var statusErrors = map[int]error{
+	http.StatusForbidden:       ErrNoPermission,
+	http.StatusTooManyRequests: ErrRateLimited,
+}
+
+// APIError is an error response from the store or Ubuntu One.
+type APIError struct {
+	URL        string
+	StatusCode int
+	Code       string
+	Message    string
+
+	// RetryAfter is how long the server asked the client to wait before retrying, if
+	// the request was rate limited.
+	RetryAfter time.Duration
+
+	// NeedsRefresh is set if the store rejected the request because the discharged
+	// macaroon used to authorize it needs refreshing.
+	NeedsRefresh bool
+
+	// reason is the known reason for the error, if any.
+	reason error
+}
+
+// Error describes the error, including the reason for it where known.
+func (e *APIError) Error() string {
+	detail := e.Message
+	if detail == "" {
+		detail = e.Code
+	}
+
+	msg := fmt.Sprintf("unexpected status %d from url '%s'", e.StatusCode, e.URL)
+	if e.reason != nil {
+		msg = fmt.Sprintf("%s (status %d from url '%s')", e.reason, e.StatusCode, e.URL)
+	}
+
+	if detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, detail)
+	}
+
+	return msg
+}
+
+// Unwrap returns the reason for the error, so it can be checked with errors.Is.
+func (e *APIError) Unwrap() error {
+	return e.reason
+}
+
+// newAPIError constructs an APIError from an unsuccessful response, closing its body.
+// Ubuntu One errors take the form {"code": ..., "message": ...}, while the store
+// returns a list of errors under 'error_list' or 'error-list'.
+func newAPIError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+
+	apiErr := &APIError{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+	}
+
+	apiErr.RetryAfter = defaultRetryAfter
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	type errorBody struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	var body struct {
+		errorBody
+		ErrorList       []errorBody `json:"error_list"`
+		ErrorListHyphen []errorBody `json:"error-list"`
+	}
+
+	respBytes, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(respBytes, &body) == nil {
+		errs := append(body.ErrorList, body.ErrorListHyphen...)
+		if len(errs) > 0 {
+			body.errorBody = errs[0]
+		}
+		apiErr.Code = body.Code
+		apiErr.Message = body.Message
+	} else {
+		apiErr.Message = string(respBytes)
+	}
+
+	apiErr.NeedsRefresh = apiErr.Code == "macaroon-needs-refresh" ||
+		strings.Contains(resp.Header.Get("WWW-Authenticate"), "needs_refresh=1")
+
+	apiErr.reason = errorCodes[apiErr.Code]
+	if apiErr.reason == nil {
+		apiErr.reason = statusErrors[apiErr.StatusCode]
+	}
+
+	return apiErr
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		expected error
+	}{
+		{http.StatusUnauthorized, `{"code": "INVALID_CREDENTIALS", "message": "Provided email/password is not correct."}`, ErrInvalidCredentials},
+		{http.StatusForbidden, `{"code": "ACCOUNT_SUSPENDED", "message": "Your account has been suspended."}`, ErrAccountSuspended},
+		{http.StatusNotFound, `{"error_list": [{"code": "resource-not-found", "message": "Snap not found"}]}`, ErrUnknownPackage},
+		{http.StatusForbidden, `{"error-list": [{"code": "permission-required", "message": "Permission denied"}]}`, ErrNoPermission},
+		{http.StatusForbidden, `Forbidden`, ErrNoPermission},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+
+		sc := &StoreClient{client: server.Client()}
+
+		_, err := sc.post(server.URL, nil)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("expected %q for response %s, got %v", tt.expected, tt.body, err)
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+			t.Errorf("expected an APIError with status %d, got %v", tt.status, err)
+		}
+
+		server.Close()
+	}
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}))
+	defer server.Close()
+
+	sc := &StoreClient{client: server.Client()}
+
+	resp, err := sc.post(server.URL, map[string]string{"key": "value"})
+	if err != nil {
+		t.Fatalf("expected the request to succeed after retrying, got %v", err)
+	}
+	resp.Body.Close()
+
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
@@ -0,0 +1,409 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/snapcrafters/tokenator/internal/config"
+	"gopkg.in/macaroon.v1"
+)
+
+// fakeDashboard is a minimal stand-in for the store dashboard and Ubuntu One login
+// service, serving just enough of each API to issue, list and revoke tokens.
+type fakeDashboard struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu      sync.Mutex
+	tokens  []IssuedToken
+	revoked []string
+	minted  int
+
+	// requested is the most recent token request, reported back by whoami unless
+	// whoami is set.
+	requested tokenRequest
+	whoami    *Whoami
+
+	// accounts maps the session ID of a token to the account whoami reports it was
+	// issued for, if not the account logged in to.
+	accounts map[string]WhoamiAccount
+
+	// totpSecret, if set, requires a valid one-time password to discharge macaroons,
+	// which may only be used once. Passwords are checked against the time given by now.
+	totpSecret string
+	usedOTPs   []string
+	now        func() time.Time
+
+	// denied maps the name of a package to the permissions the account lacks on it.
+	denied map[string][]string
+
+	// exchanged holds the macaroons issued by the Charmhub token exchange, and
+	// exchangedFor maps each of them to the session ID of the token exchanged.
+	exchanged    []string
+	exchangedFor map[string]string
+
+	// uniqueCaveats causes each root macaroon to be issued with its own Ubuntu One caveat,
+	// rather than the same caveat for every root macaroon.
+	uniqueCaveats bool
+
+	// needsRefresh causes the next request to list tokens to be rejected until the
+	// session is refreshed, and refreshed counts the discharges refreshed.
+	needsRefresh bool
+	refreshed    int
+}
+
+func newFakeDashboard(t *testing.T, tokens []IssuedToken) *fakeDashboard {
+	fd := &fakeDashboard{t: t, tokens: tokens, now: time.Now, exchangedFor: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dev/api/acl/", fd.handleACL)
+	mux.HandleFunc("/api/v2/tokens/discharge", fd.handleDischarge)
+	mux.HandleFunc("/api/v2/tokens/refresh", fd.handleRefresh)
+	mux.HandleFunc("/api/v2/tokens", fd.handleList)
+	mux.HandleFunc("/api/v2/tokens/revoke", fd.handleRevoke)
+	mux.HandleFunc("/api/v2/tokens/whoami", fd.handleWhoami)
+	mux.HandleFunc("/dev/api/account", fd.handleAccount)
+
+	// Charmhub serves its own token endpoints, and only accepts macaroons issued by its
+	// token exchange.
+	mux.HandleFunc("/v1/tokens", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			fd.handleACL(w, r)
+			return
+		}
+		fd.handleList(w, r)
+	})
+	mux.HandleFunc("/v1/tokens/exchange", fd.handleExchange)
+	mux.HandleFunc("/v1/tokens/revoke", fd.handleRevoke)
+	mux.HandleFunc("/v1/tokens/whoami", fd.handleWhoami)
+
+	fd.server = httptest.NewServer(mux)
+	t.Cleanup(fd.server.Close)
+
+	return fd
+}
+
+// client returns a StoreClient configured to use the fake dashboard.
+func (fd *fakeDashboard) client() *StoreClient {
+	authEndpoints := UBUNTU_ONE_SNAP_STORE_AUTH_ENDPOINTS
+	authEndpoints.AuthURL = fd.server.URL
+
+	return &StoreClient{
+		storeType:     SnapStore,
+		endpoints:     StoreEndpoints{BaseURL: fd.server.URL, StorageURL: fd.server.URL},
+		authEndpoints: authEndpoints,
+		credentials:   config.LoginCredentials{Login: "user@example.com", Password: "password"},
+		client:        fd.server.Client(),
+
+		sessionDescription: "tokenator-session-test",
+	}
+}
+
+// charmhubClient returns a StoreClient for Charmhub configured to use the fake dashboard.
+func (fd *fakeDashboard) charmhubClient() *StoreClient {
+	authEndpoints := UBUNTU_ONE_CHARMHUB_AUTH_ENDPOINTS
+	authEndpoints.AuthURL = fd.server.URL
+
+	return &StoreClient{
+		storeType:     Charmhub,
+		endpoints:     StoreEndpoints{BaseURL: fd.server.URL, StorageURL: fd.server.URL},
+		authEndpoints: authEndpoints,
+		credentials:   config.LoginCredentials{Login: "user@example.com", Password: "password"},
+		client:        fd.server.Client(),
+
+		sessionDescription: "tokenator-session-test",
+	}
+}
+
+// authorized reports whether a request carries the Authorization header expected by the
+// store serving it: an Ubuntu One token for the Snap Store, or a macaroon issued by the
+// token exchange for Charmhub.
+func (fd *fakeDashboard) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		return strings.HasPrefix(auth, "Macaroon root=")
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	return slices.Contains(fd.exchanged, strings.TrimPrefix(auth, "Macaroon "))
+}
+
+// sessionID returns the session ID of the token authorizing a request, which the fake
+// gives as the ID of its root macaroon.
+func (fd *fakeDashboard) sessionID(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		fd.mu.Lock()
+		defer fd.mu.Unlock()
+
+		return fd.exchangedFor[strings.TrimPrefix(auth, "Macaroon ")]
+	}
+
+	encoded, _, _ := strings.Cut(strings.TrimPrefix(auth, "Macaroon root="), ",")
+	root, err := decodeMacaroon(encoded)
+	if err != nil {
+		return ""
+	}
+	return root.Id()
+}
+
+func (fd *fakeDashboard) host() string {
+	u, _ := url.Parse(fd.server.URL)
+	return u.Host
+}
+
+func (fd *fakeDashboard) handleACL(w http.ResponseWriter, r *http.Request) {
+	fd.mu.Lock()
+	json.NewDecoder(r.Body).Decode(&fd.requested)
+
+	for _, p := range fd.requested.Packages {
+		for _, permission := range fd.requested.Permissions {
+			if slices.Contains(fd.denied[p.Name], permission) {
+				fd.mu.Unlock()
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]any{"error_list": []map[string]string{{"code": "macaroon-permission-required"}}})
+				return
+			}
+		}
+	}
+
+	// Every root macaroon is listed as a token on the account, including those for the
+	// session used to manage it and those requested to check its permissions.
+	fd.minted++
+	sessionID := fmt.Sprintf("minted-%d", fd.minted)
+	fd.tokens = append(fd.tokens, IssuedToken{
+		SessionID:   sessionID,
+		Description: fd.requested.Description,
+		CreatedAt:   time.Now(),
+	})
+
+	caveatID := "caveat-id"
+	if fd.uniqueCaveats {
+		caveatID = fmt.Sprintf("caveat-id-%d", fd.minted)
+	}
+	fd.mu.Unlock()
+
+	root, err := macaroon.New([]byte("root-key"), sessionID, "dashboard")
+	if err != nil {
+		fd.t.Fatalf("failed to create root macaroon: %v", err)
+	}
+
+	err = root.AddThirdPartyCaveat([]byte("caveat-key"), caveatID, fd.host())
+	if err != nil {
+		fd.t.Fatalf("failed to add third party caveat: %v", err)
+	}
+
+	writeMacaroon(fd.t, w, "macaroon", root)
+}
+
+func (fd *fakeDashboard) handleDischarge(w http.ResponseWriter, r *http.Request) {
+	var body macaroonDischargeParams
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if fd.totpSecret != "" {
+		if body.OTP == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"code": "TWOFACTOR_REQUIRED"})
+			return
+		}
+		valid, _ := totp.ValidateCustom(body.OTP, fd.totpSecret, fd.now(), totp.ValidateOpts{
+			Period:    otpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+
+		fd.mu.Lock()
+		reused := slices.Contains(fd.usedOTPs, body.OTP)
+		fd.usedOTPs = append(fd.usedOTPs, body.OTP)
+		fd.mu.Unlock()
+
+		if !valid || reused {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"code": "TWOFACTOR_FAILURE"})
+			return
+		}
+	}
+
+	discharge, err := macaroon.New([]byte("caveat-key"), body.CaveatId, fd.host())
+	if err != nil {
+		fd.t.Fatalf("failed to create discharge macaroon: %v", err)
+	}
+
+	writeMacaroon(fd.t, w, "discharge_macaroon", discharge)
+}
+
+func (fd *fakeDashboard) handleExchange(w http.ResponseWriter, r *http.Request) {
+	macaroonsJSON, err := base64.StdEncoding.DecodeString(r.Header.Get("Macaroons"))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var macaroons macaroon.Slice
+	err = json.Unmarshal(macaroonsJSON, &macaroons)
+	if err != nil || len(macaroons) != 2 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// The discharge macaroon must be bound to the root macaroon to verify.
+	err = macaroons[0].Verify([]byte("root-key"), func(string) error { return nil }, macaroons[1:])
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	exchanged := fmt.Sprintf("charmhub-macaroon-%d", len(fd.exchanged)+1)
+	fd.exchanged = append(fd.exchanged, exchanged)
+	fd.exchangedFor[exchanged] = macaroons[0].Id()
+
+	json.NewEncoder(w).Encode(map[string]string{"macaroon": exchanged})
+}
+
+func (fd *fakeDashboard) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	discharge, err := macaroon.New([]byte("caveat-key"), "caveat-id", fd.host())
+	if err != nil {
+		fd.t.Fatalf("failed to create discharge macaroon: %v", err)
+	}
+
+	err = discharge.AddFirstPartyCaveat("refreshed")
+	if err != nil {
+		fd.t.Fatalf("failed to add first party caveat: %v", err)
+	}
+
+	fd.mu.Lock()
+	fd.refreshed++
+	fd.mu.Unlock()
+
+	writeMacaroon(fd.t, w, "discharge_macaroon", discharge)
+}
+
+func (fd *fakeDashboard) handleList(w http.ResponseWriter, r *http.Request) {
+	if !fd.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	if fd.needsRefresh {
+		fd.needsRefresh = false
+		w.Header().Set("WWW-Authenticate", "Macaroon needs_refresh=1")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		json.NewEncoder(w).Encode(map[string]any{"macaroons": fd.tokens})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"tokens": fd.tokens})
+}
+
+func (fd *fakeDashboard) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if !fd.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.revoked = append(fd.revoked, body["session-id"])
+	fd.tokens = slices.DeleteFunc(fd.tokens, func(t IssuedToken) bool {
+		return t.SessionID == body["session-id"]
+	})
+}
+
+func (fd *fakeDashboard) handleWhoami(w http.ResponseWriter, r *http.Request) {
+	if !fd.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := fd.sessionID(r)
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	if fd.whoami != nil {
+		json.NewEncoder(w).Encode(fd.whoami)
+		return
+	}
+
+	// Charmhub reports the account's username and display name, but not its email.
+	account := WhoamiAccount{ID: "abc123", Email: "user@example.com"}
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		account = WhoamiAccount{ID: "abc123", Name: "User", Username: "user"}
+	}
+	if a, ok := fd.accounts[sessionID]; ok {
+		account = a
+	}
+
+	json.NewEncoder(w).Encode(Whoami{
+		Account:     account,
+		Packages:    fd.requested.Packages,
+		Channels:    fd.requested.Channels,
+		Permissions: fd.requested.Permissions,
+	})
+}
+
+func (fd *fakeDashboard) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if !fd.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Write([]byte(`{
+		"account_id": "abc123",
+		"snaps": {
+			"16": {
+				"helm": {"snap-id": "2", "status": "Approved", "private": false},
+				"gimp": {"snap-id": "1", "status": "Approved", "private": false}
+			}
+		}
+	}`))
+}
+
+func writeMacaroon(t *testing.T, w http.ResponseWriter, field string, m *macaroon.Macaroon) {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal macaroon: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{field: base64.RawURLEncoding.EncodeToString(b)})
+}
+
+func testTokens() []IssuedToken {
+	now := time.Now()
+	return []IssuedToken{
+		{SessionID: "1", Description: "tokenator-gimp-latest-stable", CreatedAt: now.Add(-48 * time.Hour)},
+		{SessionID: "2", Description: "tokenator-gimp-latest-stable", CreatedAt: now},
+		{SessionID: "3", Description: "tokenator-gimp-latest-stable", CreatedAt: now.Add(-24 * time.Hour)},
+		{SessionID: "4", Description: "tokenator-gimp-latest-candidate", CreatedAt: now.Add(-48 * time.Hour)},
+		{SessionID: "5", Description: "personal", CreatedAt: now.Add(-48 * time.Hour)},
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/macaroon.v1"
+)
+
+// Caveat is a first-party caveat on a macaroon. The store and Ubuntu One encode caveats
+// as '<location>|<key>|<value>'. Caveats that don't follow this form have only a Value.
+type Caveat struct {
+	Location string
+	Key      string
+	Value    string
+}
+
+// ThirdPartyCaveat is a caveat that must be discharged by the service at Location.
+type ThirdPartyCaveat struct {
+	Location string
+	ID       string
+}
+
+// MacaroonInfo describes the contents of a single macaroon.
+type MacaroonInfo struct {
+	Location          string
+	ID                string
+	Caveats           []Caveat
+	ThirdPartyCaveats []ThirdPartyCaveat
+}
+
+// Values returns the values of all the first-party caveats with the specified key.
+func (mi MacaroonInfo) Values(key string) []string {
+	values := []string{}
+	for _, c := range mi.Caveats {
+		if c.Key == key {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
+// TokenInfo describes the root and discharged macaroons that make up a UbuntuOneToken.
+type TokenInfo struct {
+	Root      MacaroonInfo
+	Discharge MacaroonInfo
+}
+
+// InspectToken decodes a base64 encoded UbuntuOneToken, as produced by GenerateStoreToken,
+// and returns a description of the caveats on its macaroons.
+func InspectToken(encoded string) (*TokenInfo, error) {
+	token, err := DecodeUbuntuOneToken(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	root, discharge, err := token.Macaroons()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenInfo{
+		Root:      describeMacaroon(root),
+		Discharge: describeMacaroon(discharge),
+	}, nil
+}
+
+// describeMacaroon splits the caveats of a macaroon into first and third-party caveats.
+func describeMacaroon(m *macaroon.Macaroon) MacaroonInfo {
+	info := MacaroonInfo{
+		Location:          m.Location(),
+		ID:                m.Id(),
+		Caveats:           []Caveat{},
+		ThirdPartyCaveats: []ThirdPartyCaveat{},
+	}
+
+	for _, c := range m.Caveats() {
+		if c.Location != "" {
+			info.ThirdPartyCaveats = append(info.ThirdPartyCaveats, ThirdPartyCaveat{Location: c.Location, ID: c.Id})
+			continue
+		}
+		info.Caveats = append(info.Caveats, parseCaveat(c.Id))
+	}
+
+	return info
+}
+
+// parseCaveat splits a first-party caveat into its location, key and value.
+func parseCaveat(id string) Caveat {
+	parts := strings.SplitN(id, "|", 3)
+	if len(parts) != 3 {
+		return Caveat{Value: id}
+	}
+	return Caveat{Location: parts[0], Key: parts[1], Value: parts[2]}
+}
+
+// String returns the caveat in its original encoded form.
+func (c Caveat) String() string {
+	if c.Key == "" {
+		return c.Value
+	}
+	return fmt.Sprintf("%s|%s|%s", c.Location, c.Key, c.Value)
+}
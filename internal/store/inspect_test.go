@@ -0,0 +1,86 @@
+package store
+
+import (
+	"slices"
+	"testing"
+
+	"gopkg.in/macaroon.v1"
+)
+
+func TestInspectToken(t *testing.T) {
+	root, err := macaroon.New([]byte("root-key"), "root-id", "dashboard.snapcraft.io")
+	if err != nil {
+		t.Fatalf("failed to create root macaroon: %v", err)
+	}
+
+	for _, c := range []string{
+		`dashboard.snapcraft.io|acl|["package_access", "package_release"]`,
+		`dashboard.snapcraft.io|channel|["latest/stable"]`,
+		`dashboard.snapcraft.io|expires|2027-01-01T00:00:00.000000`,
+		"not-a-structured-caveat",
+	} {
+		err = root.AddFirstPartyCaveat(c)
+		if err != nil {
+			t.Fatalf("failed to add caveat: %v", err)
+		}
+	}
+
+	err = root.AddThirdPartyCaveat([]byte("caveat-key"), "caveat-id", "login.ubuntu.com")
+	if err != nil {
+		t.Fatalf("failed to add third party caveat: %v", err)
+	}
+
+	discharge, err := macaroon.New([]byte("caveat-key"), "caveat-id", "login.ubuntu.com")
+	if err != nil {
+		t.Fatalf("failed to create discharge macaroon: %v", err)
+	}
+
+	err = discharge.AddFirstPartyCaveat("login.ubuntu.com|last_auth|2026-10-16T00:00:00.000000")
+	if err != nil {
+		t.Fatalf("failed to add caveat: %v", err)
+	}
+
+	token, err := NewUbuntuOneToken(root, discharge)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatalf("failed to encode token: %v", err)
+	}
+
+	info, err := InspectToken(encoded)
+	if err != nil {
+		t.Fatalf("InspectToken returned error: %v", err)
+	}
+
+	if got := info.Root.Values("acl"); !slices.Equal(got, []string{`["package_access", "package_release"]`}) {
+		t.Errorf("unexpected acl caveats: %v", got)
+	}
+
+	if got := info.Root.Values("expires"); !slices.Equal(got, []string{"2027-01-01T00:00:00.000000"}) {
+		t.Errorf("unexpected expires caveats: %v", got)
+	}
+
+	if got := info.Root.Values(""); !slices.Equal(got, []string{"not-a-structured-caveat"}) {
+		t.Errorf("unexpected unstructured caveats: %v", got)
+	}
+
+	if len(info.Root.ThirdPartyCaveats) != 1 || info.Root.ThirdPartyCaveats[0].Location != "login.ubuntu.com" {
+		t.Errorf("unexpected third party caveats: %v", info.Root.ThirdPartyCaveats)
+	}
+
+	if got := info.Discharge.Values("last_auth"); len(got) != 1 {
+		t.Errorf("unexpected last_auth caveats: %v", got)
+	}
+}
+
+func TestInspectTokenInvalid(t *testing.T) {
+	for _, encoded := range []string{"", "not base64!", "bm90IGpzb24=", "eyJ0IjoiYmVhcmVyIn0="} {
+		_, err := InspectToken(encoded)
+		if err == nil {
+			t.Errorf("expected an error inspecting %q", encoded)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// otpPeriod is the number of seconds for which each one-time password is valid.
+const otpPeriod = 30
+
+// otpGenerator generates one-time passwords for TOTP secrets, never generating the same
+// password twice. Ubuntu One rejects a password that has already been used, so once a
+// password has been generated, the next discharge waits for the following time step.
+// Discharges using the same secret are serialised, even across store clients.
+type otpGenerator struct {
+	mu sync.Mutex
+
+	// lastStep holds the time step of the password most recently generated for each
+	// secret.
+	lastStep map[string]int64
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// otps generates the one-time passwords for every StoreClient.
+var otps = newOTPGenerator(time.Now, time.Sleep)
+
+// newOTPGenerator constructs an otpGenerator using the specified clock.
+func newOTPGenerator(now func() time.Time, sleep func(time.Duration)) *otpGenerator {
+	return &otpGenerator{lastStep: map[string]int64{}, now: now, sleep: sleep}
+}
+
+// generate returns a one-time password for the secret, waiting for the next time step
+// if a password has already been generated in the current one.
+func (g *otpGenerator) generate(secret string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	step := now.Unix() / otpPeriod
+
+	if last, ok := g.lastStep[secret]; ok && step <= last {
+		next := time.Unix((last+1)*otpPeriod, 0)
+		wait := next.Sub(now)
+
+		slog.Debug("one-time password already used, waiting for the next", "wait", wait)
+		g.sleep(wait)
+
+		now = next
+		step = last + 1
+	}
+
+	code, err := totp.GenerateCode(secret, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate one-time password: %w", err)
+	}
+
+	g.lastStep[secret] = step
+	return code, nil
+}
@@ -1,5 +1,7 @@
 package store
 
+import "github.com/snapcrafters/tokenator/internal/config"
+
 // Package is a generic representation of a package in a Canonical store.
 // This could be a snap, a charm, a rock, etc.
 type Package struct {
@@ -7,10 +9,24 @@ type Package struct {
 	Type string `json:"type"`
 }
 
+// String returns the package in the form '<type>/<name>'.
+func (p Package) String() string {
+	return p.Type + "/" + p.Name
+}
+
 // NewSnapPackage constructs and returns a new package of type "snap".
 func NewSnapPackage(name string) Package {
 	return Package{
 		Name: name,
-		Type: "snap",
+		Type: config.PackageTypeSnap,
+	}
+}
+
+// NewPackages constructs a list of packages of the specified type from their names.
+func NewPackages(packageType string, names []string) []Package {
+	packages := []Package{}
+	for _, name := range names {
+		packages = append(packages, Package{Name: name, Type: packageType})
 	}
+	return packages
 }
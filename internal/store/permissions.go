@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+)
+
+// PermissionProfile is a named set of ACLs applied to a store token.
+type PermissionProfile struct {
+	Name        string
+	Permissions []string
+}
+
+// NewPermissionProfile constructs a PermissionProfile, ensuring that each of the
+// permissions is one the store accepts.
+func (s StoreType) NewPermissionProfile(name string, permissions []string) (PermissionProfile, error) {
+	if len(permissions) == 0 {
+		return PermissionProfile{}, fmt.Errorf("permission profile '%s' has no permissions", name)
+	}
+
+	for _, p := range permissions {
+		if !slices.Contains(s.Permissions, p) {
+			return PermissionProfile{}, fmt.Errorf("permission profile '%s' has invalid %s permission '%s'", name, s.Name, p)
+		}
+	}
+
+	return PermissionProfile{Name: name, Permissions: permissions}, nil
+}
+
+// DefaultPermissionProfile returns the profile applied to store tokens for the
+// specified channel when no other profile is specified, based on the channel's risk.
+func (s StoreType) DefaultPermissionProfile(channel string) (PermissionProfile, error) {
+	permissions, ok := s.ChannelPermissions[ChannelRisk(channel)]
+	if !ok {
+		return PermissionProfile{}, fmt.Errorf("no default %s permissions for channel '%s', a permission profile must be specified", s.Name, channel)
+	}
+	return PermissionProfile{Name: channel, Permissions: permissions}, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// macaroonRefreshParams represents the fields required in order to refresh a discharged macaroon.
+type macaroonRefreshParams struct {
+	DischargeMacaroon string `json:"discharge_macaroon"`
+}
+
+// RefreshToken obtains a new discharged macaroon for the token from Ubuntu One, without
+// logging in with the account password. The root macaroon, and so the token's scope and
+// expiry, is unchanged.
+func (sc *StoreClient) RefreshToken(token *UbuntuOneToken) (*UbuntuOneToken, error) {
+	root, _, err := token.Macaroons()
+	if err != nil {
+		return nil, err
+	}
+
+	body := macaroonRefreshParams{DischargeMacaroon: token.UbuntuOneMacaroons.DischargedMacaroon}
+
+	resp, err := sc.post(sc.authEndpoints.AuthURL+sc.authEndpoints.TokensRefresh, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token refresh endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	discharge, err := sc.deserializeMacaroon(resp, "discharge_macaroon")
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize macaroon: %w", err)
+	}
+
+	refreshed, err := NewUbuntuOneToken(root, discharge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a valid Ubuntu One token: %w", err)
+	}
+
+	return refreshed, nil
+}
+
+// RefreshEncodedToken refreshes a token in the base64 encoded form used by CLI tools,
+// checking that the store accepts the refreshed token before returning it. Tokens for
+// stores that exchange Ubuntu One tokens for their own, such as Charmhub, can't be
+// refreshed.
+func (sc *StoreClient) RefreshEncodedToken(encoded string) (string, error) {
+	if sc.authEndpoints.TokensStoreExchange != "" {
+		return "", fmt.Errorf("tokens issued by %s can't be refreshed, generate a new token instead", sc.storeType.Name)
+	}
+
+	token, err := DecodeUbuntuOneToken(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	refreshed, err := sc.RefreshToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = sc.Whoami(refreshed)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify refreshed token: %w", err)
+	}
+
+	return refreshed.Encode()
+}
+
+// refreshSession refreshes the discharged macaroon of the session token, after the
+// store reports that it needs refreshing. If it can't be refreshed, the client logs in
+// again to obtain a new session.
+func (sc *StoreClient) refreshSession(stale string) (string, error) {
+	sc.sessionMu.Lock()
+	defer sc.sessionMu.Unlock()
+
+	if sc.session != nil {
+		// Another request may have refreshed the session already.
+		current, err := sc.session.Authorization()
+		if err == nil && current != stale {
+			return current, nil
+		}
+
+		// Only Ubuntu One tokens can be refreshed, others are replaced by logging in again.
+		if u1Session, ok := sc.session.(*UbuntuOneToken); ok {
+			refreshed, err := sc.RefreshToken(u1Session)
+			if err == nil {
+				sc.session = refreshed
+				_, sc.discharge, err = refreshed.Macaroons()
+				if err != nil {
+					return "", fmt.Errorf("failed to decode refreshed store session: %w", err)
+				}
+				return refreshed.Authorization()
+			}
+
+			slog.Debug("failed to refresh store session, logging in again", "error", err.Error())
+		}
+		sc.session, sc.discharge = nil, nil
+	}
+
+	return sc.sessionAuthorization()
+}
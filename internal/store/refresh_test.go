@@ -0,0 +1,59 @@
+package store
+
+import (
+	"slices"
+	"testing"
+
+	"gopkg.in/macaroon.v1"
+)
+
+func TestRefreshToken(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	sc := fd.client()
+
+	login, _, err := sc.login(tokenParams{Description: "test", Credentials: sc.credentials})
+	if err != nil {
+		t.Fatalf("login returned error: %v", err)
+	}
+
+	token, ok := login.(*UbuntuOneToken)
+	if !ok {
+		t.Fatalf("expected an Ubuntu One token from the Snap Store, got %T", login)
+	}
+
+	refreshed, err := sc.RefreshToken(token)
+	if err != nil {
+		t.Fatalf("RefreshToken returned error: %v", err)
+	}
+
+	if refreshed.UbuntuOneMacaroons.RootMacaroon != token.UbuntuOneMacaroons.RootMacaroon {
+		t.Errorf("expected the root macaroon to be unchanged")
+	}
+
+	_, discharge, err := refreshed.Macaroons()
+	if err != nil {
+		t.Fatalf("refreshed token is invalid: %v", err)
+	}
+
+	if !slices.ContainsFunc(discharge.Caveats(), func(c macaroon.Caveat) bool { return c.Id == "refreshed" }) {
+		t.Errorf("expected the discharge macaroon to be refreshed")
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+	fd.needsRefresh = true
+
+	tokens, err := fd.client().ListTokens()
+	if err != nil {
+		t.Fatalf("ListTokens returned error: %v", err)
+	}
+
+	if len(tokens) != 6 {
+		t.Errorf("expected 6 tokens, got %d", len(tokens))
+	}
+
+	if fd.refreshed != 1 {
+		t.Errorf("expected the session to be refreshed once, got %d", fd.refreshed)
+	}
+}
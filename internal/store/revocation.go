@@ -0,0 +1,136 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// IssuedToken describes a token that has been issued on the store account.
+type IssuedToken struct {
+	SessionID   string    `json:"session-id"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"date-created"`
+	ExpiresAt   time.Time `json:"date-expires"`
+}
+
+// ListTokens returns the tokens currently issued on the store account.
+func (sc *StoreClient) ListTokens() ([]IssuedToken, error) {
+	resp, err := sc.authorizedRequest("GET", sc.endpoints.BaseURL+sc.authEndpoints.TokensList, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token list endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token list response body: %w", err)
+	}
+
+	// The Snap Store lists tokens as 'tokens', while Charmhub lists them as 'macaroons'.
+	var tokenList struct {
+		Tokens    []IssuedToken `json:"tokens"`
+		Macaroons []IssuedToken `json:"macaroons"`
+	}
+
+	err = json.Unmarshal(respBytes, &tokenList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token list: %w", err)
+	}
+
+	return append(tokenList.Tokens, tokenList.Macaroons...), nil
+}
+
+// listTokensDescribed returns the tokens on the store account with the specified description.
+func (sc *StoreClient) listTokensDescribed(description string) ([]IssuedToken, error) {
+	tokens, err := sc.ListTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(tokens, func(t IssuedToken) bool { return t.Description != description }), nil
+}
+
+// findIssuedToken returns the single token with the specified description that isn't
+// among the existing tokens, which is the token that has just been issued.
+func (sc *StoreClient) findIssuedToken(description string, existing []IssuedToken) (IssuedToken, error) {
+	tokens, err := sc.listTokensDescribed(description)
+	if err != nil {
+		return IssuedToken{}, err
+	}
+
+	issued := slices.DeleteFunc(tokens, func(t IssuedToken) bool {
+		return slices.ContainsFunc(existing, func(e IssuedToken) bool { return e.SessionID == t.SessionID })
+	})
+
+	switch len(issued) {
+	case 1:
+		return issued[0], nil
+	case 0:
+		return IssuedToken{}, fmt.Errorf("token not found on the store account")
+	default:
+		return IssuedToken{}, fmt.Errorf("%d tokens issued concurrently with the same description", len(issued))
+	}
+}
+
+// RevokeToken revokes a single token issued on the store account.
+func (sc *StoreClient) RevokeToken(token IssuedToken) error {
+	body := map[string]string{"session-id": token.SessionID}
+
+	resp, err := sc.authorizedRequest("POST", sc.endpoints.BaseURL+sc.authEndpoints.TokensRevoke, body)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token '%s': %w", token.Description, err)
+	}
+	resp.Body.Close()
+
+	slog.Debug("revoked store token", "description", token.Description, "session_id", token.SessionID)
+	return nil
+}
+
+// RevokeTokens revokes the tokens on the store account with the specified session IDs,
+// returning the tokens that were revoked. IDs of tokens that are no longer on the account,
+// such as those that have expired, are ignored.
+func (sc *StoreClient) RevokeTokens(ids []string) ([]IssuedToken, error) {
+	tokens, err := sc.ListTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []IssuedToken{}
+	for _, t := range tokens {
+		if !slices.Contains(ids, t.SessionID) {
+			continue
+		}
+
+		err := sc.RevokeToken(t)
+		if err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, t)
+	}
+
+	return revoked, nil
+}
+
+// RevokeByDescription revokes every token on the store account with the specified
+// description, returning the tokens that were revoked.
+func (sc *StoreClient) RevokeByDescription(description string) ([]IssuedToken, error) {
+	tokens, err := sc.listTokensDescribed(description)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []IssuedToken{}
+	for _, t := range tokens {
+		err := sc.RevokeToken(t)
+		if err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, t)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestListTokens(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+
+	tokens, err := fd.client().ListTokens()
+	if err != nil {
+		t.Fatalf("ListTokens returned error: %v", err)
+	}
+
+	// The session used to list the tokens is listed alongside them.
+	if len(tokens) != 6 {
+		t.Fatalf("expected 6 tokens, got %d", len(tokens))
+	}
+}
+
+func TestLogout(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+	sc := fd.client()
+
+	_, err := sc.ListTokens()
+	if err != nil {
+		t.Fatalf("ListTokens returned error: %v", err)
+	}
+
+	err = sc.Logout()
+	if err != nil {
+		t.Fatalf("Logout returned error: %v", err)
+	}
+
+	if !slices.Equal(fd.revoked, []string{"minted-1"}) {
+		t.Errorf("expected the session minted-1 to be revoked, got %v", fd.revoked)
+	}
+
+	if len(fd.tokens) != 5 {
+		t.Errorf("expected only the tokens set up to remain, got %v", fd.tokens)
+	}
+
+	// A client that is logged out has no session to revoke.
+	err = sc.Logout()
+	if err != nil {
+		t.Fatalf("Logout returned error: %v", err)
+	}
+
+	if len(fd.revoked) != 1 {
+		t.Errorf("expected no further tokens to be revoked, got %v", fd.revoked)
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+
+	revoked, err := fd.client().RevokeTokens([]string{"1", "3", "expired"})
+	if err != nil {
+		t.Fatalf("RevokeTokens returned error: %v", err)
+	}
+
+	if len(revoked) != 2 {
+		t.Fatalf("expected 2 tokens revoked, got %d", len(revoked))
+	}
+
+	if !slices.Equal(fd.revoked, []string{"1", "3"}) {
+		t.Errorf("expected sessions [1 3] revoked, got %v", fd.revoked)
+	}
+}
+
+func TestRevokeByDescription(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+
+	revoked, err := fd.client().RevokeByDescription("tokenator-gimp-latest-candidate")
+	if err != nil {
+		t.Fatalf("RevokeByDescription returned error: %v", err)
+	}
+
+	if len(revoked) != 1 || revoked[0].SessionID != "4" {
+		t.Errorf("expected session 4 revoked, got %v", revoked)
+	}
+
+	revoked, err = fd.client().RevokeByDescription("tokenator-unknown-latest-stable")
+	if err != nil {
+		t.Fatalf("RevokeByDescription returned error: %v", err)
+	}
+
+	if len(revoked) != 0 {
+		t.Errorf("expected no tokens revoked, got %v", revoked)
+	}
+}
+
+func TestRevokeUnauthorized(t *testing.T) {
+	fd := newFakeDashboard(t, testTokens())
+	fd.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := fd.client().RevokeByDescription("tokenator-gimp-latest-candidate")
+	if err == nil {
+		t.Fatal("expected an error when the store rejects the request")
+	}
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+)
+
+// StoreType describes a Canonical store: the environments it runs in, and the
+// permissions it can grant to tokens.
+type StoreType struct {
+	// Name identifies the store, e.g. 'snap-store'.
+	Name string
+
+	// Environments maps the name of each environment the store runs in to its endpoints.
+	Environments map[string]Environment
+
+	// Permissions is the set of ACLs that the store accepts when issuing tokens.
+	Permissions []string
+
+	// ChannelPermissions is the set of ACLs applied to tokens by default, depending on
+	// which channel the token is for interacting with.
+	ChannelPermissions map[string][]string
+
+	// SessionPermissions is the set of ACLs required to manage the tokens issued on
+	// the store account.
+	SessionPermissions []string
+}
+
+// SnapStore is the store that snaps are published to.
+var SnapStore = StoreType{
+	Name: "snap-store",
+	Environments: map[string]Environment{
+		EnvironmentProduction: {SNAP_STORE_ENDPOINTS, UBUNTU_ONE_SNAP_STORE_AUTH_ENDPOINTS},
+		EnvironmentStaging:    {SNAP_STORE_STAGING_ENDPOINTS, UBUNTU_ONE_STAGING_SNAP_STORE_AUTH_ENDPOINTS},
+	},
+	Permissions: []string{
+		"package_access",
+		"package_manage",
+		"package_metrics",
+		"package_push",
+		"package_register",
+		"package_release",
+		"package_update",
+	},
+	ChannelPermissions: map[string][]string{
+		"edge":      {"package_access", "package_push", "package_update", "package_release"},
+		"beta":      {"package_access", "package_push", "package_update", "package_release"},
+		"candidate": {"package_access", "package_push", "package_update", "package_release"},
+		"stable":    {"package_access", "package_release"},
+	},
+	SessionPermissions: []string{"package_access", "package_manage"},
+}
+
+// Charmhub is the store that charms and rocks are published to.
+var Charmhub = StoreType{
+	Name: "charmhub",
+	Environments: map[string]Environment{
+		EnvironmentProduction: {CHARMHUB_ENDPOINTS, UBUNTU_ONE_CHARMHUB_AUTH_ENDPOINTS},
+		EnvironmentStaging:    {CHARMHUB_STAGING_ENDPOINTS, UBUNTU_ONE_STAGING_CHARMHUB_AUTH_ENDPOINTS},
+	},
+	Permissions: []string{
+		"account-register-package",
+		"account-view-packages",
+		"package-manage",
+		"package-manage-acl",
+		"package-manage-metadata",
+		"package-manage-releases",
+		"package-manage-revisions",
+		"package-view",
+		"package-view-acl",
+		"package-view-metadata",
+		"package-view-metrics",
+		"package-view-releases",
+		"package-view-revisions",
+	},
+	ChannelPermissions: map[string][]string{
+		"edge":      {"package-view", "package-manage-revisions", "package-manage-metadata", "package-manage-releases"},
+		"beta":      {"package-view", "package-manage-revisions", "package-manage-metadata", "package-manage-releases"},
+		"candidate": {"package-view", "package-manage-revisions", "package-manage-metadata", "package-manage-releases"},
+		"stable":    {"package-view", "package-manage-releases"},
+	},
+	SessionPermissions: []string{"package-view", "package-manage"},
+}
+
+// StoreTypes lists each of the stores that Tokenator can issue tokens for.
+var StoreTypes = []StoreType{SnapStore, Charmhub}
+
+// StoreTypeFor returns the store that packages of the specified type are published to.
+func StoreTypeFor(packageType string) (StoreType, error) {
+	for _, s := range StoreTypes {
+		for _, env := range s.Environments {
+			if slices.Contains(env.AuthEndpoints.ValidPackageTypes, packageType) {
+				return s, nil
+			}
+		}
+	}
+	return StoreType{}, fmt.Errorf("unknown package type '%s'", packageType)
+}
+
+// LookupStoreType returns the store with the specified name.
+func LookupStoreType(name string) (StoreType, error) {
+	idx := slices.IndexFunc(StoreTypes, func(s StoreType) bool { return s.Name == name })
+	if idx < 0 {
+		return StoreType{}, fmt.Errorf("unknown store '%s'", name)
+	}
+	return StoreTypes[idx], nil
+}
+
+// Environment returns the endpoints of the store environment named in the config,
+// defaulting to production, with any custom URLs in the config applied on top.
+func (s StoreType) Environment(cfg config.Store) (Environment, error) {
+	name := cfg.Environment
+	if name == "" {
+		name = EnvironmentProduction
+	}
+
+	env, ok := s.Environments[name]
+	if !ok {
+		return Environment{}, fmt.Errorf("unknown %s environment '%s'", s.Name, name)
+	}
+
+	overrides := []struct {
+		field string
+		value string
+		dest  *string
+	}{
+		{"base_url", cfg.BaseURL, &env.Endpoints.BaseURL},
+		{"storage_url", cfg.StorageURL, &env.Endpoints.StorageURL},
+		{"auth_url", cfg.AuthURL, &env.AuthEndpoints.AuthURL},
+	}
+
+	for _, o := range overrides {
+		if o.value == "" {
+			continue
+		}
+
+		u, err := url.Parse(o.value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return Environment{}, fmt.Errorf("invalid %s %s '%s'", s.Name, o.field, o.value)
+		}
+
+		*o.dest = o.value
+	}
+
+	return env, nil
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+)
+
+func TestEnvironment(t *testing.T) {
+	env, err := SnapStore.Environment(config.Store{})
+	if err != nil {
+		t.Fatalf("NewEnvironment returned error: %v", err)
+	}
+	if env.Endpoints != SNAP_STORE_ENDPOINTS {
+		t.Errorf("expected production endpoints by default, got %+v", env.Endpoints)
+	}
+
+	env, err = SnapStore.Environment(config.Store{Environment: "staging", AuthURL: "http://localhost:8080"})
+	if err != nil {
+		t.Fatalf("NewEnvironment returned error: %v", err)
+	}
+	if env.Endpoints != SNAP_STORE_STAGING_ENDPOINTS {
+		t.Errorf("expected staging endpoints, got %+v", env.Endpoints)
+	}
+	if env.AuthEndpoints.AuthURL != "http://localhost:8080" {
+		t.Errorf("expected auth url to be overridden, got %s", env.AuthEndpoints.AuthURL)
+	}
+}
+
+func TestEnvironmentInvalid(t *testing.T) {
+	for _, cfg := range []config.Store{
+		{Environment: "development"},
+		{BaseURL: "localhost"},
+	} {
+		_, err := SnapStore.Environment(cfg)
+		if err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestStoreTypeFor(t *testing.T) {
+	for packageType, expected := range map[string]string{
+		"snap":  "snap-store",
+		"charm": "charmhub",
+		"rock":  "charmhub",
+	} {
+		s, err := StoreTypeFor(packageType)
+		if err != nil {
+			t.Fatalf("StoreTypeFor returned error: %v", err)
+		}
+		if s.Name != expected {
+			t.Errorf("expected %s packages to use %s, got %s", packageType, expected, s.Name)
+		}
+	}
+
+	_, err := StoreTypeFor("deb")
+	if err == nil {
+		t.Errorf("expected an error for an unknown package type")
+	}
+}
@@ -2,12 +2,25 @@ package store
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/snapcrafters/tokenator/internal/config"
 	"gopkg.in/macaroon.v1"
 )
 
+// Token is a store token that can be encoded for use with CLI tools, and used to
+// authenticate requests to the store.
+type Token interface {
+	// Encode returns the token in the base64 encoded form used by CLI tools.
+	Encode() (string, error)
+
+	// Authorization returns the value of an Authorization header that authenticates
+	// requests to the store using the token.
+	Authorization() (string, error)
+}
+
 // NewUbuntuOneToken constructs a valid UbuntuOneToken given a input root token, and
 // discharged token.
 func NewUbuntuOneToken(rootMacaroon *macaroon.Macaroon, dischargedMacaroon *macaroon.Macaroon) (*UbuntuOneToken, error) {
@@ -30,6 +43,26 @@ func NewUbuntuOneToken(rootMacaroon *macaroon.Macaroon, dischargedMacaroon *maca
 	}, nil
 }
 
+// DecodeUbuntuOneToken decodes a UbuntuOneToken from the base64 encoded form used by CLI tools.
+func DecodeUbuntuOneToken(encoded string) (*UbuntuOneToken, error) {
+	tokenJSON, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token from base64: %w", err)
+	}
+
+	token := &UbuntuOneToken{}
+	err = json.Unmarshal(tokenJSON, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token JSON: %w", err)
+	}
+
+	if token.TokenType != "u1-macaroon" {
+		return nil, fmt.Errorf("unsupported token type '%s'", token.TokenType)
+	}
+
+	return token, nil
+}
+
 // UbuntuOneToken represents the top-level token object that is returned to the client
 // encoded in base64 for use with CLI tools.
 type UbuntuOneToken struct {
@@ -37,6 +70,127 @@ type UbuntuOneToken struct {
 	UbuntuOneMacaroons ubuntuOneMacaroons `json:"v"`
 }
 
+// Encode returns the token in the base64 encoded form used by CLI tools.
+func (t *UbuntuOneToken) Encode() (string, error) {
+	tokenJSON, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Ubuntu One token to JSON: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(tokenJSON), nil
+}
+
+// Macaroons deserializes and returns the root and discharged macaroons held in the token.
+func (t *UbuntuOneToken) Macaroons() (*macaroon.Macaroon, *macaroon.Macaroon, error) {
+	root, err := decodeMacaroon(t.UbuntuOneMacaroons.RootMacaroon)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode root macaroon: %w", err)
+	}
+
+	discharge, err := decodeMacaroon(t.UbuntuOneMacaroons.DischargedMacaroon)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode discharged macaroon: %w", err)
+	}
+
+	return root, discharge, nil
+}
+
+// Authorization returns the value of an Authorization header that authenticates
+// requests to the store using the token. The discharged macaroon is bound to the
+// root macaroon, as required by the store.
+func (t *UbuntuOneToken) Authorization() (string, error) {
+	_, bound, err := t.boundMacaroons()
+	if err != nil {
+		return "", err
+	}
+
+	binaryBound, err := bound.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal bound macaroon to binary format: %w", err)
+	}
+
+	return fmt.Sprintf("Macaroon root=%s, discharge=%s",
+		t.UbuntuOneMacaroons.RootMacaroon, base64.RawURLEncoding.EncodeToString(binaryBound)), nil
+}
+
+// bakeryMacaroons returns the root macaroon and bound discharged macaroon of the token,
+// serialized in the JSON form used by macaroon bakery and base64 encoded.
+func (t *UbuntuOneToken) bakeryMacaroons() (string, error) {
+	root, bound, err := t.boundMacaroons()
+	if err != nil {
+		return "", err
+	}
+
+	macaroonsJSON, err := json.Marshal(macaroon.Slice{root, bound})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal macaroons to JSON: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(macaroonsJSON), nil
+}
+
+// boundMacaroons returns the root macaroon of the token, and a copy of the discharged
+// macaroon bound to it.
+func (t *UbuntuOneToken) boundMacaroons() (*macaroon.Macaroon, *macaroon.Macaroon, error) {
+	root, discharge, err := t.Macaroons()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bound := discharge.Clone()
+	bound.Bind(root.Signature())
+
+	return root, bound, nil
+}
+
+// CharmhubToken is a token for Charmhub, which holds the macaroon issued by Charmhub in
+// exchange for the discharged macaroons of an UbuntuOneToken. It is encoded in the form
+// used by charmcraft.
+type CharmhubToken struct {
+	Macaroon string
+}
+
+// DecodeCharmhubToken decodes a CharmhubToken from the base64 encoded form used by charmcraft.
+func DecodeCharmhubToken(encoded string) (*CharmhubToken, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token from base64: %w", err)
+	}
+
+	if len(decoded) == 0 {
+		return nil, fmt.Errorf("token is empty")
+	}
+
+	return &CharmhubToken{Macaroon: string(decoded)}, nil
+}
+
+// Encode returns the token in the base64 encoded form used by charmcraft.
+func (t *CharmhubToken) Encode() (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte(t.Macaroon)), nil
+}
+
+// Authorization returns the value of an Authorization header that authenticates
+// requests to Charmhub using the token.
+func (t *CharmhubToken) Authorization() (string, error) {
+	return "Macaroon " + t.Macaroon, nil
+}
+
+// decodeMacaroon deserializes a macaroon from its URL-safe base64 binary encoding.
+func decodeMacaroon(encoded string) (*macaroon.Macaroon, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode macaroon: %w", err)
+	}
+
+	mac := &macaroon.Macaroon{}
+	err = mac.UnmarshalBinary(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize macaroon: %w", err)
+	}
+
+	return mac, nil
+}
+
 // ubuntuOneMacaroons represents the two macaroons that are present in a UbuntuOneToken.
 type ubuntuOneMacaroons struct {
 	RootMacaroon       string `json:"r"`
@@ -49,8 +203,8 @@ type tokenRequest struct {
 	Permissions []string  `json:"permissions"`
 	Description string    `json:"description"`
 	TTL         int       `json:"ttl"`
-	Packages    []Package `json:"packages"`
-	Channels    []string  `json:"channels"`
+	Packages    []Package `json:"packages,omitempty"`
+	Channels    []string  `json:"channels,omitempty"`
 }
 
 // tokenParams is a data structure containing all the fields required to login to a
@@ -59,14 +213,26 @@ type tokenParams struct {
 	Channels    []string
 	Credentials config.LoginCredentials
 	Description string
-	Packages    []string
+	Packages    []Package
 	Permissions []string
 	TTL         int
 }
 
+// request returns the request for a root macaroon with the scope of the token.
+func (p tokenParams) request() tokenRequest {
+	return tokenRequest{
+		Permissions: p.Permissions,
+		Description: p.Description,
+		TTL:         p.TTL,
+		Packages:    p.Packages,
+		Channels:    p.Channels,
+	}
+}
+
 // macaroonDischargeParams represents the fields required in order to discharge a macaroon.
 type macaroonDischargeParams struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	OTP      string `json:"otp,omitempty"`
 	CaveatId string `json:"caveat_id"`
 }
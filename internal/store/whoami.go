@@ -0,0 +1,140 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Whoami describes the account and scope of a token, as reported by the store.
+type Whoami struct {
+	Account     WhoamiAccount `json:"account"`
+	Packages    []Package     `json:"packages"`
+	Channels    []string      `json:"channels"`
+	Permissions []string      `json:"permissions"`
+}
+
+// WhoamiAccount describes the store account a token was issued for.
+type WhoamiAccount struct {
+	ID       string `json:"id"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+// Whoami asks the store to describe the specified token.
+func (sc *StoreClient) Whoami(token Token) (*Whoami, error) {
+	auth, err := token.Authorization()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sc.request("GET", sc.endpoints.BaseURL+sc.authEndpoints.Whoami, auth, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request whoami endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read whoami response body: %w", err)
+	}
+
+	whoami := &Whoami{}
+	err = json.Unmarshal(respBytes, whoami)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal whoami response: %w", err)
+	}
+
+	return whoami, nil
+}
+
+// verifyToken checks that the store reports the token as belonging to the logged in
+// account, with exactly the packages, channels and permissions that were requested.
+// The account is compared by ID, as the stores differ in whether they report its email.
+func (sc *StoreClient) verifyToken(token Token, params tokenParams) error {
+	whoami, err := sc.Whoami(token)
+	if err != nil {
+		return err
+	}
+
+	accountID, err := sc.sessionAccountID()
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+
+	if whoami.Account.ID != accountID {
+		problems = append(problems, fmt.Sprintf("account is '%s', expected '%s'", whoami.Account.ID, accountID))
+	}
+
+	packages := packageStrings(whoami.Packages)
+	requested := packageStrings(params.Packages)
+
+	if !sameElements(packages, requested) {
+		problems = append(problems, fmt.Sprintf("packages are %v, expected %v", packages, requested))
+	}
+
+	if !sameElements(whoami.Channels, params.Channels) {
+		problems = append(problems, fmt.Sprintf("channels are %v, expected %v", whoami.Channels, params.Channels))
+	}
+
+	if !sameElements(whoami.Permissions, params.Permissions) {
+		problems = append(problems, fmt.Sprintf("permissions are %v, expected %v", whoami.Permissions, params.Permissions))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("token does not match request: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// sessionAccountID returns the ID of the account logged in to, as reported by the store
+// for the session token.
+func (sc *StoreClient) sessionAccountID() (string, error) {
+	sc.sessionMu.Lock()
+	defer sc.sessionMu.Unlock()
+
+	if sc.accountID != "" {
+		return sc.accountID, nil
+	}
+
+	_, err := sc.sessionAuthorization()
+	if err != nil {
+		return "", err
+	}
+
+	whoami, err := sc.Whoami(sc.session)
+	if err != nil {
+		return "", fmt.Errorf("failed to identify store account: %w", err)
+	}
+
+	if whoami.Account.ID == "" {
+		return "", fmt.Errorf("failed to identify store account: no account ID reported for the session")
+	}
+
+	sc.accountID = whoami.Account.ID
+	return sc.accountID, nil
+}
+
+// packageStrings returns each of the packages in the form '<type>/<name>'.
+func packageStrings(packages []Package) []string {
+	s := []string{}
+	for _, p := range packages {
+		s = append(s, p.String())
+	}
+	return s
+}
+
+// sameElements reports whether two lists contain the same elements, ignoring order.
+func sameElements(a, b []string) bool {
+	a = slices.Clone(a)
+	b = slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateStoreTokenVerified(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+	sc := fd.client()
+
+	profile, _ := SnapStore.DefaultPermissionProfile("stable")
+
+	token, err := sc.GenerateStoreToken("tokenator-gimp-latest-STORE_STABLE", []Package{NewSnapPackage("gimp")}, "latest", "stable", profile, DefaultTokenTTL)
+	if err != nil {
+		t.Fatalf("GenerateStoreToken returned error: %v", err)
+	}
+
+	_, err = DecodeUbuntuOneToken(token.Value)
+	if err != nil {
+		t.Errorf("GenerateStoreToken returned an invalid token: %v", err)
+	}
+
+	// The session used to list tokens is minted first.
+	if token.SessionID != "minted-2" {
+		t.Errorf("expected the token to be identified as session minted-2, got '%s'", token.SessionID)
+	}
+
+	err = sc.Logout()
+	if err != nil {
+		t.Fatalf("Logout returned error: %v", err)
+	}
+
+	if len(fd.tokens) != 1 || fd.tokens[0].SessionID != token.SessionID {
+		t.Errorf("expected only the generated token to remain once logged out, got %v", fd.tokens)
+	}
+}
+
+func TestGenerateStoreTokenMismatch(t *testing.T) {
+	now := time.Now()
+	fd := newFakeDashboard(t, []IssuedToken{
+		{SessionID: "1", Description: "tokenator-gimp-latest-STORE_STABLE", CreatedAt: now.Add(-2 * time.Hour)},
+		// The clock of the store may differ from the local one, so an existing token
+		// can appear newer than the one just generated.
+		{SessionID: "2", Description: "tokenator-gimp-latest-STORE_STABLE", CreatedAt: now.Add(time.Hour)},
+	})
+
+	fd.whoami = &Whoami{
+		Account:     WhoamiAccount{ID: "abc123", Email: "user@example.com"},
+		Packages:    []Package{NewSnapPackage("gimp")},
+		Channels:    []string{"latest/stable"},
+		Permissions: []string{"package_access", "package_push", "package_release"},
+	}
+
+	profile, _ := SnapStore.DefaultPermissionProfile("stable")
+
+	_, err := fd.client().GenerateStoreToken("tokenator-gimp-latest-STORE_STABLE", []Package{NewSnapPackage("gimp")}, "latest", "stable", profile, DefaultTokenTTL)
+	if err == nil || !strings.Contains(err.Error(), "permissions") {
+		t.Fatalf("expected a permissions mismatch error, got %v", err)
+	}
+
+	if len(fd.revoked) != 1 || fd.revoked[0] != "minted-2" {
+		t.Errorf("expected only the unverified token to be revoked, got %v", fd.revoked)
+	}
+}
+
+func TestGenerateStoreTokenOtherAccount(t *testing.T) {
+	fd := newFakeDashboard(t, []IssuedToken{})
+
+	// The token is reported with the email of the account logged in to, but belongs to
+	// another account.
+	fd.accounts = map[string]WhoamiAccount{"minted-2": {ID: "xyz789", Email: "user@example.com"}}
+
+	profile, _ := SnapStore.DefaultPermissionProfile("stable")
+
+	_, err := fd.client().GenerateStoreToken("tokenator-gimp-latest-STORE_STABLE", []Package{NewSnapPackage("gimp")}, "latest", "stable", profile, DefaultTokenTTL)
+	if err == nil || !strings.Contains(err.Error(), "account is 'xyz789', expected 'abc123'") {
+		t.Fatalf("expected an account mismatch error, got %v", err)
+	}
+
+	if len(fd.revoked) != 1 || fd.revoked[0] != "minted-2" {
+		t.Errorf("expected only the unverified token to be revoked, got %v", fd.revoked)
+	}
+}
@@ -0,0 +1,241 @@
+package tokenator
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+	"golang.org/x/sync/errgroup"
+)
+
+// Audit statuses for a secret.
+const (
+	// AuditOK is a secret that is declared in the config and set in its environment.
+	AuditOK = "ok"
+	// AuditMissing is a secret that is declared in the config but not set in its environment.
+	AuditMissing = "missing"
+	// AuditExtra is a secret that is set in an environment but not declared in the config.
+	AuditExtra = "extra"
+	// AuditFailed is a secret whose environment could not be audited.
+	AuditFailed = "failed"
+	// AuditUnrecorded is a declared secret that is set in its environment, but not
+	// recorded in the ledger, so was set by hand or before the ledger was introduced.
+	AuditUnrecorded = "unrecorded"
+	// AuditDeleted is a declared secret that is recorded in the ledger, but no longer set
+	// in its environment.
+	AuditDeleted = "deleted"
+	// AuditModified is a secret that was set in its environment after it was recorded in
+	// the ledger, so has been changed outside of tokenator.
+	AuditModified = "modified"
+	// AuditStale is a secret whose value, as recorded in the ledger, differs from the
+	// credential that tokenator would now set.
+	AuditStale = "stale"
+	// AuditExpiring is a secret whose recorded expiry is within the renewal window.
+	AuditExpiring = "expiring"
+)
+
+// modifiedTolerance is how long after a secret is recorded in the ledger that it may
+// be updated in its environment before the audit reports it as modified. Store tokens
+// are recorded with the time they were issued, shortly before they're set.
+const modifiedTolerance = 5 * time.Minute
+
+// AuditResult is the state of a single secret in a repo's environment.
+type AuditResult struct {
+	Repo        string
+	Environment string
+	Secret      string
+	Status      string
+	UpdatedAt   time.Time
+	ExpiresAt   time.Time
+	Err         error
+}
+
+// AuditReport collects the state of each secret found while auditing. It is safe for
+// concurrent use.
+type AuditReport struct {
+	mu      sync.Mutex
+	results []AuditResult
+}
+
+// Add appends a result to the report.
+func (r *AuditReport) Add(result AuditResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.results = append(r.results, result)
+}
+
+// Results returns the results grouped by repo and environment, in the order they were added.
+func (r *AuditReport) Results() []AuditResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := slices.Clone(r.results)
+	slices.SortStableFunc(results, func(a, b AuditResult) int {
+		if c := cmp.Compare(a.Repo, b.Repo); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Environment, b.Environment)
+	})
+
+	return results
+}
+
+// Drifted returns the number of secrets whose status is anything other than ok.
+func (r *AuditReport) Drifted() int {
+	drifted := 0
+	for _, result := range r.Results() {
+		if result.Status != AuditOK {
+			drifted++
+		}
+	}
+	return drifted
+}
+
+// Print writes the report to the specified writer as a table.
+func (r *AuditReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "REPO\tENVIRONMENT\tSECRET\tSTATUS\tUPDATED\tEXPIRES\tERROR")
+	for _, result := range r.Results() {
+		updated := "-"
+		if !result.UpdatedAt.IsZero() {
+			updated = result.UpdatedAt.Format(time.RFC3339)
+		}
+		expires := "-"
+		if !result.ExpiresAt.IsZero() {
+			expires = result.ExpiresAt.Format(time.RFC3339)
+		}
+		errMsg := ""
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.Repo, result.Environment, result.Secret, result.Status, updated, expires, errMsg)
+	}
+
+	return tw.Flush()
+}
+
+// Audit compares the secrets declared in the config for each track environment with
+// those actually set in Github, and with those recorded in the ledger, optionally
+// filtering the repos to a subset. The report is printed once all repos are audited, and
+// an error is returned if any secret is not ok.
+func (m *Manager) Audit(filter []string) error {
+	ctx := context.Background()
+	report := &AuditReport{}
+
+	g := errgroup.Group{}
+	g.SetLimit(max(m.options.Concurrency, 1))
+
+	for _, repo := range m.filterRepos(filter) {
+		r := repo
+		g.Go(func() error {
+			m.auditRepo(ctx, r, report)
+			return nil
+		})
+	}
+
+	// Errors are recorded in the report rather than returned by each goroutine.
+	_ = g.Wait()
+
+	err := report.Print(os.Stdout)
+	if err != nil {
+		return fmt.Errorf("failed to print audit report: %w", err)
+	}
+
+	if drifted := report.Drifted(); drifted > 0 {
+		return fmt.Errorf("found %d secrets out of sync with the config or ledger", drifted)
+	}
+
+	return nil
+}
+
+// auditRepo compares the declared and actual secrets in each track environment of a
+// single repo, adding the results to the report.
+func (m *Manager) auditRepo(ctx context.Context, repo config.Repo, report *AuditReport) {
+	if len(repo.Tracks) == 0 {
+		repo.SetDefaults()
+	}
+
+	for _, track := range repo.Tracks {
+		actual, _, err := m.repoClient.ListEnvSecrets(ctx, repo.Name, track.Environment)
+		if err != nil {
+			report.Add(AuditResult{Repo: repo.Name, Environment: track.Environment, Status: AuditFailed, Err: err})
+			continue
+		}
+
+		for _, result := range m.auditSecrets(repo.Name, track, repo.SecretsFor(track), actual) {
+			result.Repo = repo.Name
+			result.Environment = track.Environment
+			report.Add(result)
+		}
+	}
+}
+
+// auditSecrets compares the declared secrets with those actually set in the environment
+// of a track, and with the ledger's record of each.
+func (m *Manager) auditSecrets(repo string, track config.Track, declared []config.Secret, actual []gh.EnvSecret) []AuditResult {
+	results := []AuditResult{}
+
+	for _, secret := range declared {
+		e, recorded := m.ledger.Latest(repo, track.Environment, secret.Name)
+		recorded = recorded && !e.Pruned
+
+		idx := slices.IndexFunc(actual, func(s gh.EnvSecret) bool { return s.Name == secret.Name })
+		if idx < 0 {
+			status := AuditMissing
+			if recorded {
+				status = AuditDeleted
+			}
+			results = append(results, AuditResult{Secret: secret.Name, Status: status})
+			continue
+		}
+
+		result := AuditResult{Secret: secret.Name, UpdatedAt: actual[idx].UpdatedAt}
+		result.Status = m.auditStatus(secret, result.UpdatedAt, e, recorded)
+		if recorded {
+			result.ExpiresAt = e.ExpiresAt
+		}
+		results = append(results, result)
+	}
+
+	for _, s := range actual {
+		if !slices.ContainsFunc(declared, func(secret config.Secret) bool { return secret.Name == s.Name }) {
+			results = append(results, AuditResult{Secret: s.Name, Status: AuditExtra, UpdatedAt: s.UpdatedAt})
+		}
+	}
+
+	return results
+}
+
+// auditStatus returns the status of a declared secret that is set in its environment,
+// given the latest entry recorded for it in the ledger. The Launchpad credential is set
+// on every run, but only recorded when it changes, so it's compared by fingerprint
+// rather than by when it was last set.
+func (m *Manager) auditStatus(secret config.Secret, updatedAt time.Time, e ledger.Entry, recorded bool) string {
+	switch {
+	case !recorded:
+		return AuditUnrecorded
+	case secret.Provider == config.ProviderLaunchpad:
+		if e.Fingerprint != ledger.Fingerprint(m.credentials.Launchpad) {
+			return AuditStale
+		}
+	case updatedAt.After(e.IssuedAt.Add(modifiedTolerance)):
+		return AuditModified
+	}
+
+	if !e.ExpiresAt.IsZero() && time.Until(e.ExpiresAt) <= m.renewalWindow() {
+		return AuditExpiring
+	}
+
+	return AuditOK
+}
@@ -0,0 +1,159 @@
+package tokenator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+func TestAuditSecrets(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Now()
+	track := config.Track{Name: "latest", Environment: "Candidate Branch"}
+
+	store := config.Secret{Name: "SNAP_STORE_STABLE", Provider: config.ProviderStore, Channel: "stable"}
+	launchpad := config.Secret{Name: "LP_BUILD_SECRET", Provider: config.ProviderLaunchpad}
+
+	// recorded returns the ledger entry for a store token issued the specified number of
+	// days ago, expiring after a year.
+	recorded := func(daysAgo int) ledger.Entry {
+		e := storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0)
+		e.IssuedAt = now.Add(-time.Duration(daysAgo) * day)
+		e.ExpiresAt = e.IssuedAt.Add(365 * day)
+		return e
+	}
+
+	// recordedLaunchpad returns the ledger entry for the Launchpad credential.
+	recordedLaunchpad := func(credential string) ledger.Entry {
+		return ledger.Entry{
+			Repo:        "gimp",
+			Track:       "latest",
+			Environment: "Candidate Branch",
+			Secret:      "LP_BUILD_SECRET",
+			Fingerprint: ledger.Fingerprint(credential),
+			IssuedAt:    now.Add(-100 * day),
+		}
+	}
+
+	pruned := recorded(10)
+	pruned.Pruned = true
+
+	tests := []struct {
+		name     string
+		declared config.Secret
+		entries  []ledger.Entry
+
+		// updatedAt is when the secret was last set in the environment, if it is set.
+		updatedAt time.Time
+
+		expected string
+	}{
+		{
+			name:      "recorded and set",
+			declared:  store,
+			entries:   []ledger.Entry{recorded(10)},
+			updatedAt: now.Add(-10 * day),
+			expected:  AuditOK,
+		},
+		{
+			name:     "never set",
+			declared: store,
+			expected: AuditMissing,
+		},
+		{
+			name:      "missing from the ledger",
+			declared:  store,
+			updatedAt: now.Add(-10 * day),
+			expected:  AuditUnrecorded,
+		},
+		{
+			name:      "pruned from the ledger",
+			declared:  store,
+			entries:   []ledger.Entry{pruned},
+			updatedAt: now.Add(-10 * day),
+			expected:  AuditUnrecorded,
+		},
+		{
+			name:     "recorded secret deleted",
+			declared: store,
+			entries:  []ledger.Entry{recorded(10)},
+			expected: AuditDeleted,
+		},
+		{
+			name:      "set after it was recorded",
+			declared:  store,
+			entries:   []ledger.Entry{recorded(10)},
+			updatedAt: now.Add(-5 * day),
+			expected:  AuditModified,
+		},
+		{
+			name:      "set shortly after it was issued",
+			declared:  store,
+			entries:   []ledger.Entry{recorded(10)},
+			updatedAt: now.Add(-10 * day).Add(time.Minute),
+			expected:  AuditOK,
+		},
+		{
+			name:      "expiring within the renewal window",
+			declared:  store,
+			entries:   []ledger.Entry{recorded(350)},
+			updatedAt: now.Add(-350 * day),
+			expected:  AuditExpiring,
+		},
+		{
+			name:      "launchpad credential unchanged",
+			declared:  launchpad,
+			entries:   []ledger.Entry{recordedLaunchpad("launchpad-credentials")},
+			updatedAt: now,
+			expected:  AuditOK,
+		},
+		{
+			name:      "launchpad credential changed",
+			declared:  launchpad,
+			entries:   []ledger.Entry{recordedLaunchpad("old-launchpad-credentials")},
+			updatedAt: now,
+			expected:  AuditStale,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newTestManager(config.Config{Org: "snapcrafters"}, newFakeRepoClient(), tt.entries...)
+			if err != nil {
+				t.Fatalf("failed to create manager: %v", err)
+			}
+
+			actual := []gh.EnvSecret{}
+			if !tt.updatedAt.IsZero() {
+				actual = append(actual, gh.EnvSecret{Name: tt.declared.Name, UpdatedAt: tt.updatedAt})
+			}
+
+			results := m.auditSecrets("gimp", track, []config.Secret{tt.declared}, actual)
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %+v", results)
+			}
+
+			if results[0].Status != tt.expected {
+				t.Errorf("expected status '%s', got '%s'", tt.expected, results[0].Status)
+			}
+		})
+	}
+}
+
+func TestAuditSecretsExtra(t *testing.T) {
+	m, err := newTestManager(config.Config{Org: "snapcrafters"}, newFakeRepoClient())
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	track := config.Track{Name: "latest", Environment: "Candidate Branch"}
+	actual := []gh.EnvSecret{{Name: "MY_SECRET", UpdatedAt: issued}}
+
+	results := m.auditSecrets("gimp", track, []config.Secret{}, actual)
+	if len(results) != 1 || results[0].Secret != "MY_SECRET" || results[0].Status != AuditExtra {
+		t.Errorf("expected MY_SECRET to be reported as extra, got %+v", results)
+	}
+}
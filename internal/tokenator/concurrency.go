@@ -0,0 +1,53 @@
+package tokenator
+
+import (
+	"sync"
+
+	"github.com/snapcrafters/tokenator/internal/gh"
+)
+
+// limiter bounds the number of concurrent operations performed with a given client.
+type limiter chan struct{}
+
+// newLimiter constructs a limiter permitting n concurrent operations. Values of n
+// less than 1 are treated as 1.
+func newLimiter(n int) limiter {
+	return make(limiter, max(n, 1))
+}
+
+// acquire blocks until the limiter has capacity for another operation.
+func (l limiter) acquire() {
+	l <- struct{}{}
+}
+
+// release frees capacity previously acquired from the limiter.
+func (l limiter) release() {
+	<-l
+}
+
+// patList is a list of Personal Access Tokens that is safe to share between the
+// goroutines processing each repo.
+type patList struct {
+	mu   sync.Mutex
+	pats []*gh.PAT
+}
+
+// take removes all of the PATs matching the predicate from the list and returns
+// them, ensuring that each PAT is only ever handed to a single caller.
+func (pl *patList) take(match func(*gh.PAT) bool) []*gh.PAT {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	taken := []*gh.PAT{}
+	remaining := []*gh.PAT{}
+	for _, pat := range pl.pats {
+		if match(pat) {
+			taken = append(taken, pat)
+		} else {
+			remaining = append(remaining, pat)
+		}
+	}
+	pl.pats = remaining
+
+	return taken
+}
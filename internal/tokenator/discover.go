@@ -0,0 +1,111 @@
+package tokenator
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/store"
+)
+
+// Discovery statuses for a snap.
+const (
+	// DiscoveryMissing is a snap on the store account that is not in the config.
+	DiscoveryMissing = "missing"
+	// DiscoveryExtra is a snap in the config that is not on the store account.
+	DiscoveryExtra = "extra"
+)
+
+// DiscoveredSnap is a snap whose presence differs between the store account and the config.
+type DiscoveredSnap struct {
+	Snap   string
+	Repo   string
+	Status string
+}
+
+// Discovery is the result of comparing the snaps on the store account with those
+// published from the repos in the config.
+type Discovery struct {
+	Snaps []DiscoveredSnap
+}
+
+// Discover compares the snaps on the store account with those published from each of
+// the snap repos in the config, as given by their 'snaps' lists.
+func Discover(cfg config.Config, accountSnaps []store.AccountSnap) *Discovery {
+	onAccount := []string{}
+	for _, s := range accountSnaps {
+		onAccount = append(onAccount, s.Name)
+	}
+
+	d := &Discovery{}
+	configured := []string{}
+
+	for _, repo := range cfg.Repos {
+		if repo.PackageType() != config.PackageTypeSnap {
+			continue
+		}
+
+		for _, snap := range repo.SnapNames() {
+			configured = append(configured, snap)
+			if !slices.Contains(onAccount, snap) {
+				d.Snaps = append(d.Snaps, DiscoveredSnap{Snap: snap, Repo: repo.Name, Status: DiscoveryExtra})
+			}
+		}
+	}
+
+	for _, snap := range onAccount {
+		if !slices.Contains(configured, snap) {
+			d.Snaps = append(d.Snaps, DiscoveredSnap{Snap: snap, Status: DiscoveryMissing})
+		}
+	}
+
+	return d
+}
+
+// InSync reports whether the config matches the store account.
+func (d *Discovery) InSync() bool {
+	return len(d.Snaps) == 0
+}
+
+// Print writes the differences between the store account and the config to the
+// specified writer as a table.
+func (d *Discovery) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "SNAP\tREPO\tSTATUS")
+	for _, s := range d.Snaps {
+		repo := s.Repo
+		if repo == "" {
+			repo = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Snap, repo, s.Status)
+	}
+
+	return tw.Flush()
+}
+
+// ProposedRepos returns the repo entries to merge into the config to bring it into line
+// with the store account. Each missing snap is proposed as a repo of the same name, unless
+// a repo of that name lists other snaps, in which case only its name and its list of snaps,
+// with the snap added, are proposed. Extra snaps are left to be removed by hand.
+func (d *Discovery) ProposedRepos(cfg config.Config) []config.Repo {
+	repos := []config.Repo{}
+	for _, s := range d.Snaps {
+		if s.Status != DiscoveryMissing {
+			continue
+		}
+
+		// A repo of the same name that lists other snaps is assumed to publish this one too.
+		proposed := config.Repo{Name: s.Snap}
+		idx := slices.IndexFunc(cfg.Repos, func(r config.Repo) bool { return r.Name == s.Snap })
+		if idx >= 0 {
+			proposed.Snaps = append(slices.Clone(cfg.Repos[idx].SnapNames()), s.Snap)
+		}
+
+		repos = append(repos, proposed)
+	}
+
+	return repos
+}
@@ -0,0 +1,54 @@
+package tokenator
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/store"
+)
+
+func TestProposedRepos(t *testing.T) {
+	// The repo's snaps share a backing array with spare capacity, which the proposal
+	// must not write into.
+	snaps := make([]string, 1, 2)
+	snaps[0] = "gimp-plugins"
+
+	cfg := config.Config{
+		Repos: []config.Repo{
+			{Name: "gimp", Snaps: snaps},
+			{Name: "inkscape"},
+			{Name: "mattermost-k8s", Type: config.PackageTypeCharm},
+		},
+	}
+
+	accountSnaps := []store.AccountSnap{{Name: "gimp"}, {Name: "gimp-plugins"}, {Name: "vlc"}}
+
+	d := Discover(cfg, accountSnaps)
+
+	discovered := []string{}
+	for _, s := range d.Snaps {
+		discovered = append(discovered, s.Snap+" "+s.Status)
+	}
+
+	expected := []string{"inkscape extra", "gimp missing", "vlc missing"}
+	if !slices.Equal(discovered, expected) {
+		t.Errorf("expected %v, got %v", expected, discovered)
+	}
+
+	repos := d.ProposedRepos(cfg)
+	if len(repos) != 2 {
+		t.Fatalf("expected 2 proposed repos, got %+v", repos)
+	}
+
+	if repos[0].Name != "gimp" || !slices.Equal(repos[0].Snaps, []string{"gimp-plugins", "gimp"}) {
+		t.Errorf("expected gimp to be proposed with both of its snaps, got %+v", repos[0])
+	}
+	if repos[1].Name != "vlc" || len(repos[1].Snaps) != 0 {
+		t.Errorf("expected vlc to be proposed as a new repo, got %+v", repos[1])
+	}
+
+	if snaps[:2][1] != "" {
+		t.Errorf("expected the config's list of snaps to be left unchanged, got %v", snaps[:2])
+	}
+}
@@ -0,0 +1,157 @@
+package tokenator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+// fakeRepoClient is an in-memory repoClient that records the secrets and variables set
+// in each environment.
+type fakeRepoClient struct {
+	mu sync.Mutex
+
+	// secrets maps '<repo>/<environment>/<secret>' to the time the secret was last set.
+	secrets map[string]time.Time
+
+	// variables maps '<repo>/<environment>' to the variables set in the environment.
+	variables map[string]map[string]string
+
+	// orgSecrets maps the name of each org secret to the repos that can access it.
+	orgSecrets map[string][]string
+}
+
+func newFakeRepoClient() *fakeRepoClient {
+	return &fakeRepoClient{
+		secrets:    map[string]time.Time{},
+		variables:  map[string]map[string]string{},
+		orgSecrets: map[string][]string{},
+	}
+}
+
+// newTestManager returns a manager for the specified config that uses the fake client
+// and records credentials in a ledger holding the specified entries.
+func newTestManager(cfg config.Config, rc *fakeRepoClient, entries ...ledger.Entry) (*Manager, error) {
+	l, _ := ledger.New(ledger.NewMemoryBackend())
+	for _, e := range entries {
+		err := l.Record(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		config:        cfg,
+		credentials:   config.Credentials{Launchpad: "launchpad-credentials"},
+		plan:          &Plan{},
+		report:        &Report{},
+		ledger:        l,
+		repoClient:    rc,
+		storeFailures: map[string]error{},
+		preflightErrs: map[string]error{},
+		orgSecretErrs: map[string]error{},
+	}, nil
+}
+
+// setSecret sets a secret in the fake, as if it was last updated at the specified time.
+func (rc *fakeRepoClient) setSecret(repo, environment, secretName string, updatedAt time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.secrets[repo+"/"+environment+"/"+secretName] = updatedAt
+}
+
+// hasSecret reports whether a secret is set in the fake.
+func (rc *fakeRepoClient) hasSecret(repo, environment, secretName string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	_, ok := rc.secrets[repo+"/"+environment+"/"+secretName]
+	return ok
+}
+
+func (rc *fakeRepoClient) EnsureEnvironment(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy) error {
+	return nil
+}
+
+func (rc *fakeRepoClient) SetEnvSecret(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy, secretName, secretValue string) error {
+	rc.setSecret(repo, track.Environment, secretName, time.Now())
+	return nil
+}
+
+func (rc *fakeRepoClient) EnvSecretUpdatedAt(ctx context.Context, repo string, environment string, secretName string) (time.Time, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.secrets[repo+"/"+environment+"/"+secretName], nil
+}
+
+func (rc *fakeRepoClient) ListEnvSecrets(ctx context.Context, repo string, environment string) ([]gh.EnvSecret, bool, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	secrets := []gh.EnvSecret{}
+	for key, updatedAt := range rc.secrets {
+		if name, ok := strings.CutPrefix(key, repo+"/"+environment+"/"); ok {
+			secrets = append(secrets, gh.EnvSecret{Name: name, UpdatedAt: updatedAt})
+		}
+	}
+	return secrets, true, nil
+}
+
+func (rc *fakeRepoClient) DeleteEnvSecret(ctx context.Context, repo string, environment string, secretName string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	delete(rc.secrets, repo+"/"+environment+"/"+secretName)
+	return nil
+}
+
+func (rc *fakeRepoClient) DeleteEnvironment(ctx context.Context, repo string, environment string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for key := range rc.secrets {
+		if strings.HasPrefix(key, repo+"/"+environment+"/") {
+			delete(rc.secrets, key)
+		}
+	}
+	delete(rc.variables, repo+"/"+environment)
+	return nil
+}
+
+func (rc *fakeRepoClient) SetEnvVariables(ctx context.Context, repo string, environment string, variables map[string]string, undeclared []string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	existing, ok := rc.variables[repo+"/"+environment]
+	if !ok {
+		existing = map[string]string{}
+		rc.variables[repo+"/"+environment] = existing
+	}
+
+	for _, name := range undeclared {
+		delete(existing, name)
+	}
+	for name, value := range variables {
+		existing[name] = value
+	}
+	return nil
+}
+
+func (rc *fakeRepoClient) SetOrgSecret(ctx context.Context, secretName, secretValue string, repos []string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if secretValue == "" {
+		return fmt.Errorf("no value for org secret %s", secretName)
+	}
+	rc.orgSecrets[secretName] = repos
+	return nil
+}
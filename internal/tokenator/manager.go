@@ -3,15 +3,20 @@ package tokenator
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/snapcrafters/tokenator/internal/config"
 	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
 	"github.com/snapcrafters/tokenator/internal/store"
+	"golang.org/x/sync/errgroup"
 )
 
 // Manager is the engine behind Tokenator. It's responsible for iterating
@@ -21,170 +26,330 @@ type Manager struct {
 	id          string
 	config      config.Config
 	credentials config.Credentials
+	options     Options
+	plan        *Plan
+	report      *Report
+	ledger      *ledger.Ledger
+	patLimiter  limiter
+
+	orgClient  *gh.OrgClient
+	patClient  *gh.PATClient
+	repoClient repoClient
+
+	// storeClients maps the name of each store to the client used to issue its tokens.
+	storeClients map[string]*store.StoreClient
+
+	// storeFailures maps the name of a store to an error that prevents any further
+	// tokens being issued on it, such as the account being suspended.
+	storeFailures   map[string]error
+	storeFailuresMu sync.Mutex
+
+	// preflightErrs maps the name of a repo to the reason its store tokens can't be
+	// issued, as found by the pre-flight check before any repos are processed.
+	preflightErrs map[string]error
+
+	// orgSecretErrs maps the name of each org-scoped secret set during the run to the
+	// error setting it, if any. It is populated before any repos are processed.
+	orgSecretErrs map[string]error
+}
 
-	orgClient   *gh.OrgClient
-	patClient   *gh.PATClient
-	repoClient  *gh.RepoClient
-	storeClient *store.StoreClient
+// repoClient manages the environments, secrets and variables of the org's repos. It is
+// implemented by gh.RepoClient.
+type repoClient interface {
+	EnsureEnvironment(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy) error
+	SetEnvSecret(ctx context.Context, repo string, track config.Track, policy config.EnvironmentPolicy, secretName, secretValue string) error
+	EnvSecretUpdatedAt(ctx context.Context, repo string, environment string, secretName string) (time.Time, error)
+	ListEnvSecrets(ctx context.Context, repo string, environment string) ([]gh.EnvSecret, bool, error)
+	DeleteEnvSecret(ctx context.Context, repo string, environment string, secretName string) error
+	DeleteEnvironment(ctx context.Context, repo string, environment string) error
+	SetEnvVariables(ctx context.Context, repo string, environment string, variables map[string]string, undeclared []string) error
+	SetOrgSecret(ctx context.Context, secretName, secretValue string, repos []string) error
+}
+
+// Options controls how the Manager behaves when processing repos.
+type Options struct {
+	// Force causes all secrets to be rotated, regardless of when they expire.
+	Force bool
+
+	// DryRun causes the manager to build a plan of the actions it would take,
+	// without making any changes to the Snap Store or Github.
+	DryRun bool
+
+	// Concurrency is the maximum number of repos processed at the same time.
+	Concurrency int
+
+	// PATConcurrency is the maximum number of concurrent operations made using the
+	// Github web session. This should be kept low to avoid upsetting Github.
+	PATConcurrency int
+
+	// Prune causes secrets recorded in the ledger, but no longer declared in the config,
+	// to be deleted once all repos are processed. The store tokens and Personal Access
+	// Tokens issued for them are revoked.
+	Prune bool
+
+	// PruneEnvironments causes environments that are no longer used by any track to be
+	// deleted when their secrets are pruned. Only used if Prune is set.
+	PruneEnvironments bool
+
+	// Ledger records each of the credentials issued by the manager. If nil, the
+	// credentials are recorded in memory only.
+	Ledger *ledger.Ledger
 }
 
 // NewManager constructs a new Manager configured with a set of snaps and credentials.
-func NewManager(config config.Config, credentials config.Credentials) *Manager {
+func NewManager(config config.Config, credentials config.Credentials, options Options) (*Manager, error) {
+	storeClients, err := newStoreClients(config, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	l := options.Ledger
+	if l == nil {
+		// A memory backend never fails to load, so the error can be ignored.
+		l, _ = ledger.New(ledger.NewMemoryBackend())
+	}
+
 	return &Manager{
 		id:          generateID(),
 		config:      config,
 		credentials: credentials,
+		options:     options,
+		plan:        &Plan{},
+		report:      &Report{},
+		ledger:      l,
+		patLimiter:  newLimiter(options.PATConcurrency),
+
+		orgClient:    gh.NewOrgClient(credentials.GithubApp, config.Org),
+		patClient:    gh.NewPATClient(credentials.Bot),
+		repoClient:   gh.NewRepoClient(credentials.GithubToken, config.Org),
+		storeClients: storeClients,
+
+		storeFailures: map[string]error{},
+		preflightErrs: map[string]error{},
+		orgSecretErrs: map[string]error{},
+	}, nil
+}
+
+// newStoreClients constructs a client for each of the stores that tokens can be issued for.
+func newStoreClients(cfg config.Config, credentials config.Credentials) (map[string]*store.StoreClient, error) {
+	clients := map[string]*store.StoreClient{}
+	for _, storeType := range store.StoreTypes {
+		client, err := NewStoreClient(cfg, credentials, storeType)
+		if err != nil {
+			return nil, err
+		}
+		clients[storeType.Name] = client
+	}
+	return clients, nil
+}
 
-		orgClient:   gh.NewOrgClient(credentials.GithubApp, config.Org),
-		patClient:   gh.NewPATClient(credentials.Bot),
-		repoClient:  gh.NewRepoClient(credentials.GithubToken, config.Org),
-		storeClient: store.NewSnapStoreClient(credentials.SnapStore),
+// logout revokes the session of each store client. A failure is only logged, as the
+// session tokens expire shortly after anyway.
+func (m *Manager) logout() {
+	for name, client := range m.storeClients {
+		err := client.Logout()
+		if err != nil {
+			slog.Warn("failed to log out of store", "store", name, "error", err.Error())
+		}
 	}
 }
 
+// NewStoreClient constructs a client for the specified store, using the environment
+// and credentials configured for it. Charmhub credentials default to those for the
+// Snap Store, as both are Ubuntu One accounts.
+func NewStoreClient(cfg config.Config, credentials config.Credentials, storeType store.StoreType) (*store.StoreClient, error) {
+	storeConfig, storeCredentials := cfg.Store, credentials.SnapStore
+	if storeType.Name == store.Charmhub.Name {
+		storeConfig = cfg.Charmhub
+		if credentials.Charmhub.Login != "" {
+			storeCredentials = credentials.Charmhub
+		}
+	}
+
+	env, err := storeType.Environment(storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure store environment: %w", err)
+	}
+
+	return store.NewStoreClient(storeType, storeCredentials, env), nil
+}
+
 // Process instructs the manager to iterate over the list of snaps it's configured
-// with, optionally filtering the list to a subset.
+// with, optionally filtering the list to a subset. The store account's rights to publish
+// each snap are checked, and any org-scoped secrets are set, before any repo is
+// processed. Repos are processed concurrently, bounded by the configured concurrency.
+// Failures are recorded against the relevant repo, track and secret, and processing
+// continues with the next secret. A summary of the results is printed once all repos are
+// processed, and an error is returned if any secret could not be set. If pruning is
+// enabled, secrets that are no longer declared in the config are deleted after the repos
+// are processed. The sessions used to manage the store accounts are revoked once done.
 func (m *Manager) Process(filter []string) error {
 	ctx := context.Background()
+	defer m.logout()
 
 	// Get the list of previously configured Personal Access Tokens, as some of these
-	// will be deleted as they're superseded.
-	pats, err := m.patClient.List("token8r")
-	if err != nil {
-		return fmt.Errorf("failed to list personal access tokens: %w", err)
-	}
-
-	for _, repo := range m.filterRepos(filter) {
-		if len(repo.Tracks) == 0 {
-			repo.SetDefaults()
+	// will be deleted as they're superseded. This is skipped in dry-run mode to avoid
+	// logging into the Github web UI.
+	pats := &patList{}
+	var patsErr error
+	if !m.options.DryRun {
+		pats.pats, patsErr = m.patClient.List("token8r")
+		if patsErr != nil {
+			patsErr = fmt.Errorf("failed to list personal access tokens: %w", patsErr)
+			slog.Error(patsErr.Error())
 		}
+	}
 
-		snaps := []string{}
-		if len(repo.Snaps) > 0 {
-			snaps = repo.Snaps
-		} else {
-			snaps = []string{repo.Name}
-		}
+	repos := m.filterRepos(filter)
 
-		for _, track := range repo.Tracks {
-			// Generate the candidate store token and set it on Github
-			err := m.setStoreSecret(ctx, repo.Name, snaps, track, "candidate")
-			if err != nil {
-				return fmt.Errorf("failed to set %s/candidate store secret: %w", track.Name, err)
-			}
+	// Check the store account can publish every snap before minting any tokens. The
+	// results are only read once repos are processed concurrently below.
+	m.preflight(repos)
 
-			// Generate the candidate store token and set it on Github
-			err = m.setStoreSecret(ctx, repo.Name, snaps, track, "stable")
-			if err != nil {
-				return fmt.Errorf("failed to set %s/stable store secret: %w", track.Name, err)
-			}
+	// Secrets shared by every repo are set once for the org, before those set in each
+	// repo's environments.
+	m.setOrgSecrets(ctx, repos)
 
-			// Set the Launchpad secret
-			err = m.setLaunchpadSecret(ctx, repo.Name, track)
-			if err != nil {
-				return fmt.Errorf("failed to set Launchpad secret: %w", err)
-			}
+	g := errgroup.Group{}
+	g.SetLimit(max(m.options.Concurrency, 1))
 
-			// Generate the PAT
-			err = m.setBotCommitSecret(ctx, repo.Name, track, pats)
-			if err != nil {
-				return fmt.Errorf("failed to set bot commit secret: %w", err)
-			}
-		}
+	for _, repo := range repos {
+		r := repo
+		g.Go(func() error {
+			m.processRepo(ctx, r, pats, patsErr)
+			return nil
+		})
 	}
 
-	return nil
-}
+	// Errors are recorded in the report rather than returned by each goroutine.
+	_ = g.Wait()
 
-// filterRepos takes a list of repo names and returns a list of only those Repos
-// from the manager's config.
-func (m *Manager) filterRepos(filter []string) []config.Repo {
-	repos := m.config.Repos
-	if len(filter) > 0 {
-		filteredRepos := []config.Repo{}
-		for _, repo := range repos {
-			if slices.Contains(filter, repo.Name) {
-				filteredRepos = append(filteredRepos, repo)
-			}
-		}
-		repos = filteredRepos
+	if m.options.Prune {
+		m.prune(ctx, filter, pats, patsErr)
 	}
-	return repos
-}
 
-// setLaunchpadSecret is helper that sets the LP_BUILD_SECRET for a given repo/environment.
-func (m *Manager) setLaunchpadSecret(ctx context.Context, repo string, track config.Track) error {
-	err := m.repoClient.SetEnvSecret(ctx, repo, track, "LP_BUILD_SECRET", m.credentials.Launchpad)
-	if err != nil {
-		return fmt.Errorf("failed to set LP_BUILD_SECRET secret: %w", err)
+	if m.options.DryRun {
+		err := m.plan.Print(os.Stdout)
+		if err != nil {
+			return fmt.Errorf("failed to print plan: %w", err)
+		}
+	} else {
+		err := m.report.Print(os.Stdout)
+		if err != nil {
+			return fmt.Errorf("failed to print report: %w", err)
+		}
 	}
 
-	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
-	slog.Info("secret set", "repo", fullName, "secret_name", "LP_BUILD_SECRET", "environment", track.Environment)
+	if failed := m.report.Failed(); failed > 0 {
+		return fmt.Errorf("failed to set %d of %d secrets", failed, len(m.report.Results()))
+	}
 
 	return nil
 }
 
-// setLaunchpadSecret is helper that generates and sets the store secret for a given snap/track/environment.
-func (m *Manager) setStoreSecret(ctx context.Context, repo string, snaps []string, track config.Track, channel string) error {
-	token, err := m.storeClient.GenerateStoreToken(repo, snaps, track.Name, channel)
-	if err != nil {
-		return err
+// processRepo ensures the environment of each of the tracks of a single repo matches its
+// policy, then sets each of its variables and secrets, recording the outcome in the
+// manager's report. Copies of org-scoped secrets are deleted from each environment.
+func (m *Manager) processRepo(ctx context.Context, repo config.Repo, pats *patList, patsErr error) {
+	if len(repo.Tracks) == 0 {
+		repo.SetDefaults()
 	}
 
-	secretName := fmt.Sprintf("SNAP_STORE_%s", strings.ToUpper(channel))
+	for _, track := range repo.Tracks {
+		policy := m.config.PolicyFor(track)
 
-	err = m.repoClient.SetEnvSecret(ctx, repo, track, secretName, token)
-	if err != nil {
-		return fmt.Errorf("failed to set %s secret: %w", secretName, err)
-	}
+		if m.options.DryRun {
+			m.plan.Add(repo.Name, track.Environment, "ensure environment",
+				fmt.Sprintf("create if missing or reconcile, with deployment branches: %s, %s", strings.Join(gh.EnvironmentBranches(track), ", "), describePolicy(policy)))
+		} else {
+			// Secrets aren't set in an environment whose protection can't be enforced.
+			err := m.repoClient.EnsureEnvironment(ctx, repo.Name, track, policy)
+			if err != nil {
+				for _, secret := range repo.SecretsFor(track) {
+					m.record(repo.Name, track, secret.Name, err)
+				}
+				continue
+			}
+		}
 
-	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
-	slog.Info("secret set", "repo", fullName, "secret_name", secretName, "environment", track.Environment)
+		m.setVariables(ctx, repo, track)
+		m.deleteEnvCopies(ctx, repo, track)
 
-	return nil
+		for _, secret := range repo.SecretsFor(track) {
+			err := m.setSecret(ctx, repo, track, secret, pats, patsErr)
+			m.record(repo.Name, track, secret.Name, err)
+		}
+	}
 }
 
-// setLaunchpadSecret is helper that generates and sets the bot commit secret for a given repo/environment.
-func (m *Manager) setBotCommitSecret(ctx context.Context, repo string, track config.Track, pats []*gh.PAT) error {
-	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+// describePolicy returns a short description of an environment policy for the plan.
+// Settings that the policy doesn't set are left unchanged on existing environments.
+func describePolicy(policy config.EnvironmentPolicy) string {
+	reviewers := "unchanged"
+	if policy.Reviewers != nil {
+		names := []string{}
+		for _, r := range policy.RequiredReviewers() {
+			names = append(names, r.String())
+		}
+		if len(names) == 0 {
+			names = append(names, "none")
+		}
+		reviewers = strings.Join(names, " ")
+	}
 
-	tokenRepos := []string{fullName, "snapcrafters/ci-screenshots"}
+	waitTimer := "unchanged"
+	if policy.WaitTimer != nil {
+		waitTimer = fmt.Sprintf("%d minutes", policy.WaitMinutes())
+	}
 
-	// Create the access token on Github, which triggers a PAT approval in the org
-	pat, err := m.patClient.Create(fmt.Sprintf("token8r-%s-%s-%s", m.id, repo, track.Name), tokenRepos, m.config.Org)
-	if err != nil {
-		return fmt.Errorf("failed to create personal access token: %w", err)
+	adminsBypass := "unchanged"
+	if policy.CanAdminsBypass != nil {
+		adminsBypass = fmt.Sprintf("%t", policy.AdminsCanBypass())
 	}
 
-	// Approve the PAT request we just triggered so the new token is active
-	err = m.orgClient.ApprovePATRequest(ctx, repo)
-	if err != nil {
-		return fmt.Errorf("failed to approve personal access token request: %w", err)
+	preventSelfReview := "unchanged"
+	if policy.PreventSelfReview != nil {
+		preventSelfReview = fmt.Sprintf("%t", policy.SelfReviewPrevented())
 	}
 
-	// Set the SNAPCRAFTERS_BOT_COMMIT secret
-	err = m.repoClient.SetEnvSecret(ctx, repo, track, "SNAPCRAFTERS_BOT_COMMIT", pat.Token)
-	if err != nil {
-		return fmt.Errorf("failed to set SNAPCRAFTERS_BOT_COMMIT secret: %w", err)
+	return fmt.Sprintf("reviewers: %s, wait timer: %s, admins bypass: %s, prevent self review: %s",
+		reviewers, waitTimer, adminsBypass, preventSelfReview)
+}
+
+// record adds the outcome of setting a secret to the manager's report, logging any
+// error that occurred.
+func (m *Manager) record(repo string, track config.Track, secretName string, err error) {
+	var notDue *notDueError
+	if err != nil && !errors.As(err, &notDue) {
+		fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+		slog.Error("failed to set secret", "repo", fullName, "secret_name", secretName, "environment", track.Environment, "error", err.Error())
 	}
 
-	slog.Info("secret set", "repo", fullName, "secret_name", "SNAPCRAFTERS_BOT_COMMIT", "environment", track.Environment)
+	m.report.Add(repo, track, secretName, err)
+}
 
-	// Iterate through the list of PATs, cleaning up redundant secrets where necessary
-	for _, pat := range pats {
-		patSuffix := fmt.Sprintf("%s-%s", repo, track.Name)
-		// If the token name contains the same suffix, but doesn't contain the ID of the
-		// manager, then it was created by a prior run and is now unneeded, so can be
-		// deleted.
-		if strings.Contains(pat.Name, patSuffix) && !strings.Contains(pat.Name, m.id) {
-			err := pat.Delete(m.patClient)
-			if err != nil {
-				return fmt.Errorf("failed to delete personal access token: %w", err)
+// filterRepos takes a list of repo names and returns a list of only those Repos
+// from the manager's config.
+func (m *Manager) filterRepos(filter []string) []config.Repo {
+	repos := m.config.Repos
+	if len(filter) > 0 {
+		filteredRepos := []config.Repo{}
+		for _, repo := range repos {
+			if slices.Contains(filter, repo.Name) {
+				filteredRepos = append(filteredRepos, repo)
 			}
 		}
+		repos = filteredRepos
 	}
+	return repos
+}
 
+// recordIssued adds an entry for a newly issued credential to the ledger.
+func (m *Manager) recordIssued(entry ledger.Entry) error {
+	err := m.ledger.Record(entry)
+	if err != nil {
+		return fmt.Errorf("secret set, but failed to record it in the ledger: %w", err)
+	}
 	return nil
 }
 
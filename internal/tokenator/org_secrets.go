@@ -0,0 +1,133 @@
+package tokenator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+// setOrgSecrets sets each org-scoped secret declared by the specified repos once for the
+// whole org, rather than in every environment. Each secret is made visible to every
+// configured repo that declares it, and no others, keeping the selected repos in sync
+// with the config. The outcome for each secret is recorded in the manager's report.
+func (m *Manager) setOrgSecrets(ctx context.Context, repos []config.Repo) {
+	names := []string{}
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+
+	for _, orgSecret := range m.config.OrgSecrets() {
+		// Only secrets used by one of the repos being processed are set, but they are
+		// always made visible to every repo that declares them.
+		if !slices.ContainsFunc(orgSecret.Repos, func(r string) bool { return slices.Contains(names, r) }) {
+			continue
+		}
+
+		err := m.setOrgSecret(ctx, orgSecret)
+		if err != nil {
+			slog.Error("failed to set org secret", "org", m.config.Org, "secret_name", orgSecret.Secret.Name, "error", err.Error())
+		}
+		m.orgSecretErrs[orgSecret.Secret.Name] = err
+
+		m.report.Add(m.config.Org, config.Track{}, orgSecret.Secret.Name, err)
+	}
+}
+
+// setOrgSecret issues the value for a single org-scoped secret and sets it for the org.
+func (m *Manager) setOrgSecret(ctx context.Context, orgSecret config.OrgSecret) error {
+	secret := orgSecret.Secret
+
+	// Other providers issue a credential scoped to a single repo, which mustn't be
+	// shared with the rest of the org.
+	if secret.Provider != config.ProviderLaunchpad {
+		return fmt.Errorf("secrets from the '%s' provider can't be set for the org", secret.Provider)
+	}
+
+	if m.options.DryRun {
+		m.plan.Add(m.config.Org, "", "set org secret", fmt.Sprintf("%s, visibility: selected, repos: %s", secret.Name, strings.Join(orgSecret.Repos, ",")))
+		return nil
+	}
+
+	err := m.repoClient.SetOrgSecret(ctx, secret.Name, m.credentials.Launchpad, orgSecret.Repos)
+	if err != nil {
+		return fmt.Errorf("failed to set %s secret: %w", secret.Name, err)
+	}
+
+	slog.Info("org secret set", "org", m.config.Org, "secret_name", secret.Name, "repos", strings.Join(orgSecret.Repos, ","))
+
+	return nil
+}
+
+// deleteEnvCopies deletes the copies of the track's org-scoped secrets from its
+// environment, such as those set before the secret was moved to the org. Environment
+// secrets take precedence over org secrets, so a copy would otherwise shadow the org
+// secret. Copies are only deleted once the org secret has been set, and the outcome of
+// each deletion is recorded in the manager's report.
+func (m *Manager) deleteEnvCopies(ctx context.Context, repo config.Repo, track config.Track) {
+	for _, secret := range repo.OrgSecretsFor(track) {
+		if err, ok := m.orgSecretErrs[secret.Name]; !ok || err != nil {
+			continue
+		}
+
+		if m.options.DryRun {
+			m.plan.Add(repo.Name, track.Environment, "delete secret", fmt.Sprintf("%s if set, as it is set for the org", secret.Name))
+			continue
+		}
+
+		deleted, err := m.deleteEnvCopy(ctx, repo.Name, track, secret.Name)
+		if err != nil {
+			fullName := fmt.Sprintf("%s/%s", m.config.Org, repo.Name)
+			slog.Error("failed to delete secret", "repo", fullName, "secret_name", secret.Name, "environment", track.Environment, "error", err.Error())
+		}
+
+		if deleted || err != nil {
+			m.report.AddPruned(repo.Name, track, secret.Name, err)
+		}
+	}
+}
+
+// deleteEnvCopy deletes a single org-scoped secret from the track's environment, if it
+// is set there, and reports whether it was deleted. The deletion is recorded in the
+// ledger if the secret was previously recorded there.
+func (m *Manager) deleteEnvCopy(ctx context.Context, repo string, track config.Track, secretName string) (bool, error) {
+	updatedAt, err := m.repoClient.EnvSecretUpdatedAt(ctx, repo, track.Environment, secretName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether %s is set in the environment: %w", secretName, err)
+	}
+
+	if updatedAt.IsZero() {
+		return false, nil
+	}
+
+	err = m.repoClient.DeleteEnvSecret(ctx, repo, track.Environment, secretName)
+	if err != nil {
+		return false, err
+	}
+
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+	slog.Info("secret deleted, as it is set for the org", "repo", fullName, "secret_name", secretName, "environment", track.Environment)
+
+	if e, ok := m.ledger.Latest(repo, track.Environment, secretName); !ok || e.Pruned {
+		return true, nil
+	}
+
+	err = m.ledger.Record(ledger.Entry{
+		Repo:        repo,
+		Track:       track.Name,
+		Environment: track.Environment,
+		Secret:      secretName,
+		Pruned:      true,
+		IssuedAt:    time.Now(),
+	})
+	if err != nil {
+		return true, fmt.Errorf("secret deleted, but failed to record it in the ledger: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package tokenator
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+func TestOrgScopeDeletesEnvironmentCopy(t *testing.T) {
+	cfg := config.Config{
+		Org: "snapcrafters",
+		Repos: []config.Repo{
+			{
+				Name:    "gimp",
+				Secrets: []config.Secret{{Name: "LP_BUILD_SECRET", Provider: config.ProviderLaunchpad, Scope: config.ScopeOrg}},
+			},
+			{
+				Name:    "helm",
+				Secrets: []config.Secret{{Name: "LP_BUILD_SECRET", Provider: config.ProviderLaunchpad, Scope: config.ScopeOrg}},
+			},
+		},
+	}
+
+	rc := newFakeRepoClient()
+	rc.setSecret("gimp", "Candidate Branch", "LP_BUILD_SECRET", issued)
+	rc.setSecret("gimp", "Candidate Branch", "SNAP_STORE_STABLE", issued)
+
+	m, err := newTestManager(cfg, rc, ledger.Entry{
+		Repo:        "gimp",
+		Track:       "latest",
+		Environment: "Candidate Branch",
+		Secret:      "LP_BUILD_SECRET",
+		Fingerprint: ledger.Fingerprint("launchpad-credentials"),
+		IssuedAt:    issued,
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	ctx := context.Background()
+	m.setOrgSecrets(ctx, cfg.Repos)
+	for _, repo := range cfg.Repos {
+		m.processRepo(ctx, repo, &patList{}, nil)
+	}
+
+	if !slices.Equal(rc.orgSecrets["LP_BUILD_SECRET"], []string{"gimp", "helm"}) {
+		t.Errorf("expected the org secret to be visible to gimp and helm, got %v", rc.orgSecrets["LP_BUILD_SECRET"])
+	}
+
+	if rc.hasSecret("gimp", "Candidate Branch", "LP_BUILD_SECRET") {
+		t.Errorf("expected the environment copy of the org secret to be deleted")
+	}
+	if !rc.hasSecret("gimp", "Candidate Branch", "SNAP_STORE_STABLE") {
+		t.Errorf("expected other environment secrets to be kept")
+	}
+
+	e, _ := m.ledger.Latest("gimp", "Candidate Branch", "LP_BUILD_SECRET")
+	if !e.Pruned {
+		t.Errorf("expected the deletion to be recorded in the ledger, got %+v", e)
+	}
+
+	// Besides the org secret, only the deleted copy is reported, as helm's environment had none.
+	results := m.report.Results()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	if results[0].Repo != "gimp" || results[0].Status() != "pruned" {
+		t.Errorf("expected the deleted copy to be reported as pruned, got %+v", results[0])
+	}
+}
+
+func TestOrgScopeKeepsCopyIfOrgSecretFails(t *testing.T) {
+	cfg := config.Config{
+		Org: "snapcrafters",
+		Repos: []config.Repo{
+			{
+				Name:    "gimp",
+				Secrets: []config.Secret{{Name: "LP_BUILD_SECRET", Provider: config.ProviderLaunchpad, Scope: config.ScopeOrg}},
+			},
+		},
+	}
+
+	rc := newFakeRepoClient()
+	rc.setSecret("gimp", "Candidate Branch", "LP_BUILD_SECRET", issued)
+
+	m, err := newTestManager(cfg, rc)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	m.credentials.Launchpad = ""
+
+	ctx := context.Background()
+	m.setOrgSecrets(ctx, cfg.Repos)
+	m.processRepo(ctx, cfg.Repos[0], &patList{}, nil)
+
+	if !rc.hasSecret("gimp", "Candidate Branch", "LP_BUILD_SECRET") {
+		t.Errorf("expected the environment copy to be kept when the org secret can't be set")
+	}
+}
@@ -0,0 +1,64 @@
+package tokenator
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+)
+
+// Action represents a single change that the manager would make to a repo
+// when not running in dry-run mode.
+type Action struct {
+	Repo        string
+	Environment string
+	Kind        string
+	Detail      string
+}
+
+// Plan is an ordered list of the actions the manager would take during a run. It is
+// safe for concurrent use.
+type Plan struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+// Add appends an action to the plan.
+func (p *Plan) Add(repo, environment, kind, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.actions = append(p.actions, Action{
+		Repo:        repo,
+		Environment: environment,
+		Kind:        kind,
+		Detail:      detail,
+	})
+}
+
+// Actions returns the list of actions grouped by repo, in the order they were planned.
+func (p *Plan) Actions() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	actions := slices.Clone(p.actions)
+	slices.SortStableFunc(actions, func(a, b Action) int {
+		return cmp.Compare(a.Repo, b.Repo)
+	})
+
+	return actions
+}
+
+// Print writes the plan to the specified writer as a table.
+func (p *Plan) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "REPO\tENVIRONMENT\tACTION\tDETAIL")
+	for _, a := range p.Actions() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Repo, a.Environment, a.Kind, a.Detail)
+	}
+
+	return tw.Flush()
+}
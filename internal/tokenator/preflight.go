@@ -0,0 +1,150 @@
+package tokenator
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/store"
+)
+
+// approvedStatus is the status of a snap on the store account that can be pushed to
+// and released.
+const approvedStatus = "Approved"
+
+// preflight checks that the store account can push and release each of the snaps
+// published from the specified repos, with the permissions their store secrets need,
+// before any tokens are minted. Problems are logged up front, and recorded so that the
+// store secrets of affected repos fail without attempting to mint a token. Repos
+// publishing other types of package, or without store secrets, are not checked.
+func (m *Manager) preflight(repos []config.Repo) {
+	toCheck := []config.Repo{}
+	for _, repo := range repos {
+		if repo.PackageType() == config.PackageTypeSnap && hasStoreSecrets(repo) {
+			toCheck = append(toCheck, repo)
+		}
+	}
+
+	if len(toCheck) == 0 {
+		return
+	}
+
+	if m.options.DryRun {
+		for _, repo := range toCheck {
+			m.plan.Add(repo.Name, "", "check store publisher rights", fmt.Sprintf("%s, permissions: %s",
+				strings.Join(repo.SnapNames(), ","), strings.Join(m.requiredPermissions(repo), ",")))
+		}
+		return
+	}
+
+	storeClient := m.storeClients[store.SnapStore.Name]
+
+	accountSnaps, err := storeClient.AccountSnaps()
+	if err != nil {
+		err = fmt.Errorf("failed to list snaps on the store account: %w", err)
+		slog.Error("pre-flight check failed", "error", err.Error())
+		m.recordStoreFailure(store.SnapStore, err)
+		for _, repo := range toCheck {
+			m.preflightErrs[repo.Name] = err
+		}
+		return
+	}
+
+	for _, repo := range toCheck {
+		err := m.checkPublisherRights(storeClient, repo, accountSnaps)
+		if err != nil {
+			fullName := fmt.Sprintf("%s/%s", m.config.Org, repo.Name)
+			slog.Error("pre-flight check failed", "repo", fullName, "error", err.Error())
+			m.recordStoreFailure(store.SnapStore, err)
+			m.preflightErrs[repo.Name] = err
+		}
+	}
+}
+
+// checkPublisherRights returns an error listing each of the repo's snaps that the
+// store account is not a collaborator on, that is not approved for publishing, or on
+// which the account lacks any of the permissions needed by the repo's store secrets,
+// naming the missing permissions.
+func (m *Manager) checkPublisherRights(storeClient *store.StoreClient, repo config.Repo, accountSnaps []store.AccountSnap) error {
+	permissions := m.requiredPermissions(repo)
+
+	problems := []string{}
+	for _, name := range repo.SnapNames() {
+		idx := slices.IndexFunc(accountSnaps, func(s store.AccountSnap) bool { return s.Name == name })
+		switch {
+		case idx < 0:
+			problems = append(problems, fmt.Sprintf("%s (account is not a collaborator)", name))
+			continue
+		case accountSnaps[idx].Status != approvedStatus:
+			problems = append(problems, fmt.Sprintf("%s (status is '%s')", name, accountSnaps[idx].Status))
+			continue
+		}
+
+		if len(permissions) == 0 {
+			continue
+		}
+
+		missing, err := storeClient.MissingPermissions(store.NewSnapPackage(name), permissions)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s (missing permissions %s)", name, strings.Join(missing, ",")))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("store account cannot publish %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// requiredPermissions returns the store permissions needed by the store secrets of a
+// repo, sorted by name. Secrets with an invalid permission profile are ignored, as they
+// fail with their own error.
+func (m *Manager) requiredPermissions(repo config.Repo) []string {
+	if len(repo.Tracks) == 0 {
+		repo.SetDefaults()
+	}
+
+	permissions := []string{}
+	for _, track := range repo.Tracks {
+		for _, secret := range repo.SecretsFor(track) {
+			if secret.Provider != config.ProviderStore {
+				continue
+			}
+
+			profile, err := m.permissionProfile(store.SnapStore, secret)
+			if err != nil {
+				continue
+			}
+
+			for _, p := range profile.Permissions {
+				if !slices.Contains(permissions, p) {
+					permissions = append(permissions, p)
+				}
+			}
+		}
+	}
+
+	slices.Sort(permissions)
+	return permissions
+}
+
+// hasStoreSecrets reports whether any of the repo's tracks has a store secret.
+func hasStoreSecrets(repo config.Repo) bool {
+	if len(repo.Tracks) == 0 {
+		repo.SetDefaults()
+	}
+
+	for _, track := range repo.Tracks {
+		for _, secret := range repo.SecretsFor(track) {
+			if secret.Provider == config.ProviderStore {
+				return true
+			}
+		}
+	}
+	return false
+}
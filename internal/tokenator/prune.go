@@ -0,0 +1,262 @@
+package tokenator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+	"github.com/snapcrafters/tokenator/internal/store"
+)
+
+// staleSecret is a secret recorded in the ledger that is no longer declared in the config.
+type staleSecret struct {
+	track  config.Track
+	secret string
+
+	// history is the list of ledger entries for credentials issued to the secret since
+	// it was last pruned, ordered from most to least recently issued.
+	history []ledger.Entry
+}
+
+// staleEnvironment groups the stale secrets of a single environment in a repo.
+type staleEnvironment struct {
+	repo        string
+	environment string
+	secrets     []staleSecret
+
+	// declared is set if the environment is still used by one of the repo's tracks,
+	// in which case it is kept even if pruning environments.
+	declared bool
+}
+
+// prune deletes the secrets recorded in the ledger that the config no longer declares,
+// along with the store tokens and Personal Access Tokens issued for them. If enabled,
+// environments that are no longer used by any track are deleted too. Only the repos in
+// the filter are pruned, or every repo in the ledger if the filter is empty. The outcome
+// for each secret is recorded in the manager's report.
+//
+// Only secrets recorded in the ledger are pruned. Secrets set before the ledger was
+// introduced, or by hand, are never deleted; the audit command reports them instead.
+func (m *Manager) prune(ctx context.Context, filter []string, pats *patList, patsErr error) {
+	for _, env := range m.staleEnvironments(filter) {
+		deleteEnvironment := m.options.PruneEnvironments && !env.declared
+
+		if m.options.DryRun {
+			m.planPrune(env, deleteEnvironment)
+			continue
+		}
+
+		// Deleting the environment deletes all of its secrets, so they don't need to
+		// be deleted individually.
+		var envErr error
+		if deleteEnvironment {
+			envErr = m.repoClient.DeleteEnvironment(ctx, env.repo, env.environment)
+			if envErr != nil {
+				envErr = fmt.Errorf("failed to delete environment: %w", envErr)
+			} else {
+				slog.Info("environment deleted", "repo", fmt.Sprintf("%s/%s", m.config.Org, env.repo), "environment", env.environment)
+			}
+		}
+
+		for _, s := range env.secrets {
+			err := envErr
+			if err == nil {
+				err = m.pruneSecret(ctx, env.repo, s, !deleteEnvironment, pats, patsErr)
+			}
+
+			if err != nil {
+				fullName := fmt.Sprintf("%s/%s", m.config.Org, env.repo)
+				slog.Error("failed to prune secret", "repo", fullName, "secret_name", s.secret, "environment", env.environment, "error", err.Error())
+			}
+
+			m.report.AddPruned(env.repo, s.track, s.secret, err)
+		}
+	}
+}
+
+// planPrune adds the actions taken to prune the secrets of an environment to the plan.
+func (m *Manager) planPrune(env *staleEnvironment, deleteEnvironment bool) {
+	if deleteEnvironment {
+		m.plan.Add(env.repo, env.environment, "delete environment", env.environment)
+	}
+
+	for _, s := range env.secrets {
+		if !deleteEnvironment {
+			m.plan.Add(env.repo, env.environment, "delete secret", s.secret)
+		}
+
+		for _, e := range s.history {
+			if e.TokenID != "" {
+				m.plan.Add(env.repo, env.environment, "revoke store token", fmt.Sprintf("%s (id %s)", e.Description, e.TokenID))
+			}
+			if e.PATID != "" {
+				m.plan.Add(env.repo, env.environment, "delete personal access token", fmt.Sprintf("%s (id %s)", e.PATName, e.PATID))
+			}
+		}
+	}
+}
+
+// pruneSecret deletes a single stale secret, optionally leaving the secret itself in
+// place if its environment has already been deleted. Any store tokens and Personal
+// Access Tokens recorded in the ledger for the secret are revoked, and the pruning is
+// recorded in the ledger so the secret isn't pruned again. Store tokens are revoked by
+// the session ID recorded for them, so that tokens issued for other secrets are never
+// touched.
+func (m *Manager) pruneSecret(ctx context.Context, repo string, s staleSecret, deleteSecret bool, pats *patList, patsErr error) error {
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+
+	// The secret is deleted first, so that nothing is left using a revoked credential.
+	if deleteSecret {
+		err := m.repoClient.DeleteEnvSecret(ctx, repo, s.track.Environment, s.secret)
+		if err != nil {
+			return err
+		}
+		slog.Info("secret deleted", "repo", fullName, "secret_name", s.secret, "environment", s.track.Environment)
+	}
+
+	patIDs := []string{}
+	for _, e := range s.history {
+		if e.PATID != "" {
+			patIDs = append(patIDs, e.PATID)
+		}
+
+		if e.TokenID == "" {
+			continue
+		}
+
+		storeName := e.Store
+		if storeName == "" {
+			storeName = store.SnapStore.Name
+		}
+
+		storeClient, ok := m.storeClients[storeName]
+		if !ok {
+			return fmt.Errorf("secret deleted, but cannot revoke store tokens for unknown store '%s'", storeName)
+		}
+
+		revoked, err := storeClient.RevokeTokens([]string{e.TokenID})
+		if err != nil {
+			return fmt.Errorf("secret deleted, but failed to revoke store tokens: %w", err)
+		}
+
+		for _, t := range revoked {
+			slog.Info("store token revoked", "repo", fullName, "description", t.Description, "session_id", t.SessionID, "created_at", t.CreatedAt)
+		}
+	}
+
+	if len(patIDs) > 0 {
+		if patsErr != nil {
+			return fmt.Errorf("secret deleted, but cannot delete personal access tokens: %w", patsErr)
+		}
+
+		m.patLimiter.acquire()
+		defer m.patLimiter.release()
+
+		for _, pat := range pats.take(func(p *gh.PAT) bool { return slices.Contains(patIDs, p.ID) }) {
+			err := pat.Delete(m.patClient)
+			if err != nil {
+				return fmt.Errorf("secret deleted, but failed to delete personal access token: %w", err)
+			}
+			slog.Info("personal access token deleted", "repo", fullName, "pat_name", pat.Name)
+		}
+	}
+
+	err := m.ledger.Record(ledger.Entry{
+		Repo:        repo,
+		Track:       s.track.Name,
+		Environment: s.track.Environment,
+		Secret:      s.secret,
+		Pruned:      true,
+		IssuedAt:    time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("secret pruned, but failed to record it in the ledger: %w", err)
+	}
+
+	return nil
+}
+
+// staleEnvironments returns the environments containing secrets recorded in the ledger
+// that are no longer declared in the config, ordered by repo and environment. Secrets
+// that have already been pruned are ignored. Only secrets recorded in the ledger are
+// considered to be managed by tokenator, so secrets set before the ledger existed are
+// never returned.
+func (m *Manager) staleEnvironments(filter []string) []*staleEnvironment {
+	declaredSecrets := map[string]bool{}
+	declaredEnvs := map[string]bool{}
+	for _, repo := range m.config.Repos {
+		if len(repo.Tracks) == 0 {
+			repo.SetDefaults()
+		}
+
+		for _, track := range repo.Tracks {
+			declaredEnvs[repo.Name+"/"+track.Environment] = true
+			for _, secret := range repo.SecretsFor(track) {
+				declaredSecrets[repo.Name+"/"+track.Environment+"/"+secret.Name] = true
+			}
+		}
+	}
+
+	envs := map[string]*staleEnvironment{}
+	seen := map[string]bool{}
+	for _, e := range m.ledger.Entries() {
+		if len(filter) > 0 && !slices.Contains(filter, e.Repo) {
+			continue
+		}
+
+		// Entries for variables, which are deleted when they are reconciled rather than
+		// pruned, and for revoked legacy store tokens record no secret.
+		if e.Secret == "" {
+			continue
+		}
+
+		key := e.Repo + "/" + e.Environment + "/" + e.Secret
+		if seen[key] || declaredSecrets[key] {
+			continue
+		}
+		seen[key] = true
+
+		history := []ledger.Entry{}
+		for _, h := range m.ledger.History(e.Repo, e.Environment, e.Secret) {
+			if h.Pruned {
+				break
+			}
+			history = append(history, h)
+		}
+
+		if len(history) == 0 {
+			continue
+		}
+
+		envKey := e.Repo + "/" + e.Environment
+		env, ok := envs[envKey]
+		if !ok {
+			env = &staleEnvironment{repo: e.Repo, environment: e.Environment, declared: declaredEnvs[envKey]}
+			envs[envKey] = env
+		}
+
+		env.secrets = append(env.secrets, staleSecret{
+			track:   config.Track{Name: history[0].Track, Environment: e.Environment},
+			secret:  e.Secret,
+			history: history,
+		})
+	}
+
+	stale := []*staleEnvironment{}
+	for _, env := range envs {
+		slices.SortFunc(env.secrets, func(a, b staleSecret) int { return strings.Compare(a.secret, b.secret) })
+		stale = append(stale, env)
+	}
+
+	slices.SortFunc(stale, func(a, b *staleEnvironment) int {
+		return strings.Compare(a.repo+"/"+a.environment, b.repo+"/"+b.environment)
+	})
+
+	return stale
+}
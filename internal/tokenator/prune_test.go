@@ -0,0 +1,159 @@
+package tokenator
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+// issued is the time at which the first credential in each test ledger was issued.
+var issued = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// storeEntry returns a ledger entry for a store token issued for a secret the specified
+// number of days after the first.
+func storeEntry(repo, track, environment, secret string, days int) ledger.Entry {
+	return ledger.Entry{
+		Repo:        repo,
+		Track:       track,
+		Environment: environment,
+		Secret:      secret,
+		Description: fmt.Sprintf("tokenator-%s-%s-%s", repo, track, secret),
+		TokenID:     fmt.Sprintf("%s-%d", secret, days),
+		IssuedAt:    issued.Add(time.Duration(days) * 24 * time.Hour),
+	}
+}
+
+// prunedEntry returns a ledger entry recording that a secret was pruned the specified
+// number of days after the first credential was issued.
+func prunedEntry(repo, track, environment, secret string, days int) ledger.Entry {
+	return ledger.Entry{
+		Repo:        repo,
+		Track:       track,
+		Environment: environment,
+		Secret:      secret,
+		Pruned:      true,
+		IssuedAt:    issued.Add(time.Duration(days) * 24 * time.Hour),
+	}
+}
+
+// describeStale summarises stale environments as
+// '<repo>/<environment>/<secret> (<entries>, <declared>)', one per secret.
+func describeStale(envs []*staleEnvironment) []string {
+	described := []string{}
+	for _, env := range envs {
+		for _, s := range env.secrets {
+			described = append(described, fmt.Sprintf("%s/%s/%s (%d, %t)", env.repo, env.environment, s.secret, len(s.history), env.declared))
+		}
+	}
+	return described
+}
+
+func TestStaleEnvironments(t *testing.T) {
+	gimp := config.Repo{
+		Name: "gimp",
+		Secrets: []config.Secret{
+			{Name: "SNAP_STORE_EDGE", Provider: config.ProviderStore, Channel: "edge"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		entries  []ledger.Entry
+		filter   []string
+		expected []string
+	}{
+		{
+			name: "declared secret",
+			entries: []ledger.Entry{
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_EDGE", 0),
+			},
+			expected: []string{},
+		},
+		{
+			name: "renamed secret",
+			entries: []ledger.Entry{
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_BETA", 0),
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_BETA", 1),
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_EDGE", 2),
+			},
+			expected: []string{"gimp/Candidate Branch/SNAP_STORE_BETA (2, true)"},
+		},
+		{
+			name: "removed track",
+			entries: []ledger.Entry{
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_EDGE", 0),
+				storeEntry("gimp", "1.0", "1.0 Branch", "SNAP_STORE_EDGE", 0),
+			},
+			expected: []string{"gimp/1.0 Branch/SNAP_STORE_EDGE (1, false)"},
+		},
+		{
+			name: "removed repo",
+			entries: []ledger.Entry{
+				storeEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0),
+				storeEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_CANDIDATE", 0),
+			},
+			expected: []string{
+				"helm/Candidate Branch/SNAP_STORE_CANDIDATE (1, false)",
+				"helm/Candidate Branch/SNAP_STORE_STABLE (1, false)",
+			},
+		},
+		{
+			name: "removed repo outside filter",
+			entries: []ledger.Entry{
+				storeEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0),
+			},
+			filter:   []string{"gimp"},
+			expected: []string{},
+		},
+		{
+			name: "undeclared variable",
+			entries: []ledger.Entry{
+				{Repo: "gimp", Track: "latest", Environment: "Candidate Branch", Variable: "STORE_TRACK", IssuedAt: issued},
+			},
+			expected: []string{},
+		},
+		{
+			name: "already pruned",
+			entries: []ledger.Entry{
+				storeEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0),
+				prunedEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 1),
+			},
+			expected: []string{},
+		},
+		{
+			name: "issued again after pruning",
+			entries: []ledger.Entry{
+				storeEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0),
+				prunedEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 1),
+				storeEntry("helm", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 2),
+			},
+			expected: []string{"helm/Candidate Branch/SNAP_STORE_STABLE (1, false)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := ledger.New(ledger.NewMemoryBackend())
+			for _, e := range tt.entries {
+				err := l.Record(e)
+				if err != nil {
+					t.Fatalf("Record returned error: %v", err)
+				}
+			}
+
+			m := &Manager{
+				config: config.Config{Org: "snapcrafters", Repos: []config.Repo{gimp}},
+				ledger: l,
+			}
+
+			stale := describeStale(m.staleEnvironments(tt.filter))
+			if !slices.Equal(stale, tt.expected) {
+				t.Errorf("expected stale secrets %v, got %v", tt.expected, stale)
+			}
+		})
+	}
+}
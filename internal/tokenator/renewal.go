@@ -0,0 +1,143 @@
+package tokenator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+// defaultRenewalWindow is the period before a secret expires during which it will be
+// rotated, if no renewal window is configured.
+const defaultRenewalWindow = 30 * 24 * time.Hour
+
+// notDueError is returned when a secret was not rotated because it is not yet within
+// the renewal window.
+type notDueError struct {
+	expiresAt time.Time
+}
+
+func (e *notDueError) Error() string {
+	return fmt.Sprintf("not due for renewal, expires %s", e.expiresAt.Format(time.DateOnly))
+}
+
+// renewalWindow returns the configured renewal window, or the default if unset.
+func (m *Manager) renewalWindow() time.Duration {
+	if m.config.RenewalWindow > 0 {
+		return time.Duration(m.config.RenewalWindow) * 24 * time.Hour
+	}
+	return defaultRenewalWindow
+}
+
+// checkRenewal determines whether a secret with the specified TTL needs rotating. A
+// secret is rotated if it is within the renewal window of expiring, if it no longer
+// exists in its environment, or if the scope recorded in the ledger for its current
+// value differs from the wanted scope, such as after its channel or profile is changed.
+// The expiry recorded in the ledger is used where available, otherwise the expiry is
+// based on the time at which the secret was last set. In dry-run mode only the ledger is
+// consulted, so that Github isn't contacted. A notDueError is returned if the secret
+// does not yet need rotating.
+func (m *Manager) checkRenewal(ctx context.Context, repo string, track config.Track, secretName string, want ledger.Entry, ttl time.Duration) error {
+	if m.options.Force {
+		return nil
+	}
+
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+
+	e, ok := m.ledger.Latest(repo, track.Environment, secretName)
+	if ok && !e.Pruned && !e.ExpiresAt.IsZero() {
+		if changes := scopeChanges(e, want, ttl); len(changes) > 0 {
+			slog.Info("secret scope changed, reissuing", "repo", fullName, "secret_name", secretName, "environment", track.Environment, "changed", strings.Join(changes, ","))
+			return nil
+		}
+
+		if !m.options.DryRun {
+			updatedAt, err := m.repoClient.EnvSecretUpdatedAt(ctx, repo, track.Environment, secretName)
+			if err != nil {
+				return fmt.Errorf("failed to check when %s was last set: %w", secretName, err)
+			}
+
+			// The secret was deleted, or its environment recreated, since it was issued.
+			if updatedAt.IsZero() {
+				slog.Info("secret missing from environment, reissuing", "repo", fullName, "secret_name", secretName, "environment", track.Environment)
+				return nil
+			}
+		}
+
+		return m.checkDue(repo, track, secretName, e.ExpiresAt)
+	}
+
+	// Without a ledger entry, the expiry can only be found from Github.
+	if m.options.DryRun {
+		return nil
+	}
+
+	updatedAt, err := m.repoClient.EnvSecretUpdatedAt(ctx, repo, track.Environment, secretName)
+	if err != nil {
+		return fmt.Errorf("failed to check when %s was last set: %w", secretName, err)
+	}
+
+	// The secret has never been set, so must be issued.
+	if updatedAt.IsZero() {
+		return nil
+	}
+
+	return m.checkDue(repo, track, secretName, updatedAt.Add(ttl))
+}
+
+// checkDue returns a notDueError if a secret expiring at the specified time is not yet
+// within the renewal window. In dry-run mode, the skipped secret is added to the plan.
+func (m *Manager) checkDue(repo string, track config.Track, secretName string, expiresAt time.Time) error {
+	if time.Until(expiresAt) <= m.renewalWindow() {
+		return nil
+	}
+
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+	slog.Debug("secret not due for renewal", "repo", fullName, "secret_name", secretName, "environment", track.Environment, "expires_at", expiresAt)
+
+	if m.options.DryRun {
+		m.plan.Add(repo, track.Environment, "skip secret", fmt.Sprintf("%s, not due for renewal until %s", secretName, expiresAt.Add(-m.renewalWindow()).Format(time.DateOnly)))
+	}
+
+	return &notDueError{expiresAt: expiresAt}
+}
+
+// scopeChanges returns the aspects of the scope recorded for a credential in the ledger
+// that differ from the wanted scope. Aspects not recorded in the ledger, as for entries
+// recorded by earlier versions, are assumed to be unchanged.
+func scopeChanges(recorded ledger.Entry, want ledger.Entry, ttl time.Duration) []string {
+	changes := []string{}
+
+	issuedTTL := recorded.ExpiresAt.Sub(recorded.IssuedAt)
+	if issuedTTL-ttl > time.Minute || ttl-issuedTTL > time.Minute {
+		changes = append(changes, "ttl")
+	}
+	if recorded.Channel != "" && recorded.Channel != want.Channel {
+		changes = append(changes, "channel")
+	}
+	if recorded.Profile != "" && recorded.Profile != want.Profile {
+		changes = append(changes, "profile")
+	}
+	if recorded.Permissions != nil && !sameElements(recorded.Permissions, want.Permissions) {
+		changes = append(changes, "permissions")
+	}
+	if recorded.Packages != nil && !sameElements(recorded.Packages, want.Packages) {
+		changes = append(changes, "packages")
+	}
+
+	return changes
+}
+
+// sameElements reports whether two lists contain the same elements, ignoring order.
+func sameElements(a, b []string) bool {
+	a = slices.Clone(a)
+	b = slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,196 @@
+package tokenator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+func TestCheckRenewal(t *testing.T) {
+	day := 24 * time.Hour
+	ttl := 365 * day
+	now := time.Now()
+	track := config.Track{Name: "latest", Environment: "Candidate Branch"}
+
+	want := ledger.Entry{
+		Channel:     "latest/stable",
+		Profile:     "stable",
+		Permissions: []string{"package_access", "package_push", "package_release"},
+		Packages:    []string{"snap/gimp"},
+	}
+
+	// recorded returns the ledger entry for a token issued the specified number of days
+	// ago, with the wanted scope modified by the function.
+	recorded := func(daysAgo int, modify func(e *ledger.Entry)) *ledger.Entry {
+		e := want
+		e.Repo = "gimp"
+		e.Track = "latest"
+		e.Environment = "Candidate Branch"
+		e.Secret = "SNAP_STORE_STABLE"
+		e.TokenID = "session-1"
+		e.IssuedAt = now.Add(-time.Duration(daysAgo) * day)
+		e.ExpiresAt = e.IssuedAt.Add(ttl)
+		if modify != nil {
+			modify(&e)
+		}
+		return &e
+	}
+
+	tests := []struct {
+		name  string
+		entry *ledger.Entry
+
+		// updatedAt is when the secret was last set in the environment, if it is set.
+		updatedAt time.Time
+
+		force  bool
+		dryRun bool
+		due    bool
+	}{
+		{
+			name:      "expiry outside the window",
+			entry:     recorded(10, nil),
+			updatedAt: now.Add(-10 * day),
+			due:       false,
+		},
+		{
+			name:      "expiry inside the window",
+			entry:     recorded(350, nil),
+			updatedAt: now.Add(-350 * day),
+			due:       true,
+		},
+		{
+			name:      "forced",
+			entry:     recorded(10, nil),
+			updatedAt: now.Add(-10 * day),
+			force:     true,
+			due:       true,
+		},
+		{
+			name:      "channel changed",
+			entry:     recorded(10, func(e *ledger.Entry) { e.Channel = "latest/candidate" }),
+			updatedAt: now.Add(-10 * day),
+			due:       true,
+		},
+		{
+			name:      "profile changed",
+			entry:     recorded(10, func(e *ledger.Entry) { e.Profile = "candidate" }),
+			updatedAt: now.Add(-10 * day),
+			due:       true,
+		},
+		{
+			name:      "permissions changed",
+			entry:     recorded(10, func(e *ledger.Entry) { e.Permissions = []string{"package_access", "package_push"} }),
+			updatedAt: now.Add(-10 * day),
+			due:       true,
+		},
+		{
+			name:      "packages changed",
+			entry:     recorded(10, func(e *ledger.Entry) { e.Packages = []string{"snap/gimp", "snap/gimp-plugins"} }),
+			updatedAt: now.Add(-10 * day),
+			due:       true,
+		},
+		{
+			name:      "ttl changed",
+			entry:     recorded(10, func(e *ledger.Entry) { e.ExpiresAt = e.IssuedAt.Add(90 * day) }),
+			updatedAt: now.Add(-10 * day),
+			due:       true,
+		},
+		{
+			name: "permissions in a different order",
+			entry: recorded(10, func(e *ledger.Entry) {
+				e.Permissions = []string{"package_release", "package_push", "package_access"}
+			}),
+			updatedAt: now.Add(-10 * day),
+			due:       false,
+		},
+		{
+			name:      "scope not recorded",
+			entry:     recorded(10, func(e *ledger.Entry) { e.Channel, e.Profile, e.Permissions, e.Packages = "", "", nil, nil }),
+			updatedAt: now.Add(-10 * day),
+			due:       false,
+		},
+		{
+			name:  "secret deleted from the environment",
+			entry: recorded(10, nil),
+			due:   true,
+		},
+		{
+			name:   "secret deleted from the environment in dry-run",
+			entry:  recorded(10, nil),
+			dryRun: true,
+			due:    false,
+		},
+		{
+			name:      "no ledger entry, recently set",
+			updatedAt: now.Add(-10 * day),
+			due:       false,
+		},
+		{
+			name:      "no ledger entry, set within the window of expiring",
+			updatedAt: now.Add(-350 * day),
+			due:       true,
+		},
+		{
+			name: "no ledger entry, never set",
+			due:  true,
+		},
+		{
+			name:      "no ledger entry in dry-run",
+			updatedAt: now.Add(-10 * day),
+			dryRun:    true,
+			due:       true,
+		},
+		{
+			name:  "declared again after pruning",
+			entry: recorded(10, func(e *ledger.Entry) { e.Pruned = true }),
+			due:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newFakeRepoClient()
+			if !tt.updatedAt.IsZero() {
+				rc.setSecret("gimp", "Candidate Branch", "SNAP_STORE_STABLE", tt.updatedAt)
+			}
+
+			entries := []ledger.Entry{}
+			if tt.entry != nil {
+				entries = append(entries, *tt.entry)
+			}
+
+			m, err := newTestManager(config.Config{Org: "snapcrafters"}, rc, entries...)
+			if err != nil {
+				t.Fatalf("failed to create manager: %v", err)
+			}
+			m.options = Options{Force: tt.force, DryRun: tt.dryRun}
+
+			err = m.checkRenewal(context.Background(), "gimp", track, "SNAP_STORE_STABLE", want, ttl)
+
+			var notDue *notDueError
+			switch {
+			case tt.due && err != nil:
+				t.Errorf("expected the secret to be due for renewal, got %v", err)
+			case !tt.due && !errors.As(err, &notDue):
+				t.Errorf("expected the secret not to be due for renewal, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRenewalWindow(t *testing.T) {
+	m := &Manager{}
+	if m.renewalWindow() != defaultRenewalWindow {
+		t.Errorf("expected the default renewal window, got %s", m.renewalWindow())
+	}
+
+	m.config.RenewalWindow = 60
+	if m.renewalWindow() != 60*24*time.Hour {
+		t.Errorf("expected a 60 day renewal window, got %s", m.renewalWindow())
+	}
+}
@@ -0,0 +1,132 @@
+package tokenator
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+)
+
+// Result represents the outcome of setting a single secret or variable for a given repo
+// and track.
+type Result struct {
+	Repo        string
+	Track       string
+	Environment string
+	Secret      string
+	Err         error
+
+	// Variable is set if the result is for an Actions variable, named by Secret, rather
+	// than a secret.
+	Variable bool
+
+	// Pruned is set if the secret was deleted rather than set, as it is no longer
+	// declared in the config.
+	Pruned bool
+}
+
+// Status returns a short, human readable description of the result.
+func (r Result) Status() string {
+	if r.Skipped() {
+		return "skipped"
+	}
+	if r.Err != nil {
+		return "failed"
+	}
+	if r.Pruned {
+		return "pruned"
+	}
+	return "ok"
+}
+
+// Kind returns whether the result is for a secret or a variable.
+func (r Result) Kind() string {
+	if r.Variable {
+		return "variable"
+	}
+	return "secret"
+}
+
+// Skipped reports whether the secret was left untouched because it is not yet due
+// for renewal.
+func (r Result) Skipped() bool {
+	var notDue *notDueError
+	return errors.As(r.Err, &notDue)
+}
+
+// Report collects the results of each secret processed by the manager. It is safe
+// for concurrent use.
+type Report struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+// Add records the outcome of setting a secret in the report.
+func (r *Report) Add(repo string, track config.Track, secret string, err error) {
+	r.add(Result{Repo: repo, Track: track.Name, Environment: track.Environment, Secret: secret, Err: err})
+}
+
+// AddPruned records the outcome of deleting a secret that is no longer declared in the
+// config in the report.
+func (r *Report) AddPruned(repo string, track config.Track, secret string, err error) {
+	r.add(Result{Repo: repo, Track: track.Name, Environment: track.Environment, Secret: secret, Err: err, Pruned: true})
+}
+
+// AddVariable records the outcome of setting or, if pruned is set, deleting a variable
+// in the report.
+func (r *Report) AddVariable(repo string, track config.Track, variable string, pruned bool, err error) {
+	r.add(Result{Repo: repo, Track: track.Name, Environment: track.Environment, Secret: variable, Err: err, Pruned: pruned, Variable: true})
+}
+
+// add records a result in the report.
+func (r *Report) add(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.results = append(r.results, result)
+}
+
+// Results returns the list of results grouped by repo, in the order they were recorded.
+func (r *Report) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := slices.Clone(r.results)
+	slices.SortStableFunc(results, func(a, b Result) int {
+		return cmp.Compare(a.Repo, b.Repo)
+	})
+
+	return results
+}
+
+// Failed returns the number of results that represent a failure.
+func (r *Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results() {
+		if result.Err != nil && !result.Skipped() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Print writes a summary table of the results to the specified writer.
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "REPO\tTRACK\tENVIRONMENT\tKIND\tNAME\tSTATUS\tERROR")
+	for _, result := range r.Results() {
+		errMsg := ""
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.Repo, result.Track, result.Environment, result.Kind(), result.Secret, result.Status(), errMsg)
+	}
+
+	return tw.Flush()
+}
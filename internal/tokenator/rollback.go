@@ -0,0 +1,43 @@
+package tokenator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// rollback is a stack of steps that undo the completed parts of a multi-step change,
+// such that a failure part way through leaves nothing behind.
+type rollback struct {
+	steps []rollbackStep
+}
+
+// rollbackStep is a single undo operation, along with a description for logging.
+type rollbackStep struct {
+	description string
+	undo        func() error
+}
+
+// add pushes an undo operation onto the stack.
+func (r *rollback) add(description string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{description: description, undo: undo})
+}
+
+// run executes the undo operations in reverse order, returning the original error
+// joined with any errors encountered while rolling back.
+func (r *rollback) run(cause error) error {
+	errs := []error{cause}
+
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+
+		slog.Warn("rolling back", "step", step.description, "reason", cause.Error())
+
+		err := step.undo()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rollback failed to %s: %w", step.description, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
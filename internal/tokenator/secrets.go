@@ -0,0 +1,478 @@
+package tokenator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+	"github.com/snapcrafters/tokenator/internal/store"
+)
+
+// setSecret issues the value for a secret using the secret's provider, and sets it
+// in the environment for the specified track.
+func (m *Manager) setSecret(ctx context.Context, repo config.Repo, track config.Track, secret config.Secret, pats *patList, patsErr error) error {
+	ttls := m.config.TTLsFor(repo)
+
+	switch secret.Provider {
+	case config.ProviderStore:
+		if secret.Channel == "" {
+			return fmt.Errorf("no channel specified for store secret")
+		}
+		if secret.Name == "" {
+			return fmt.Errorf("no secret name specified for channel '%s'", secret.Channel)
+		}
+		storeType, err := store.StoreTypeFor(repo.PackageType())
+		if err != nil {
+			return err
+		}
+		_, err = store.QualifyChannel(track.Name, secret.Channel)
+		if err != nil {
+			return err
+		}
+		profile, err := m.permissionProfile(storeType, secret)
+		if err != nil {
+			return err
+		}
+		ttl := ttlDays(ttls.Store, store.DefaultTokenTTL)
+		err = store.ValidateTokenTTL(ttl)
+		if err != nil {
+			return err
+		}
+		err = m.storeFailure(storeType)
+		if err != nil {
+			return err
+		}
+		err = m.preflightErrs[repo.Name]
+		if err != nil {
+			return err
+		}
+		packages := store.NewPackages(repo.PackageType(), repo.SnapNames())
+		storeClient := m.storeClients[storeType.Name]
+		err = m.setStoreSecret(ctx, storeClient, repo.Name, packages, track, secret.Name, secret.Channel, profile, ttl)
+		m.recordStoreFailure(storeType, err)
+		if err != nil {
+			return err
+		}
+		return m.revokeLegacyStoreTokens(storeClient, repo, track)
+
+	case config.ProviderLaunchpad:
+		return m.setLaunchpadSecret(ctx, repo.Name, track, secret.Name)
+
+	case config.ProviderBotCommit:
+		// The PAT can't be rotated safely if the existing PATs could not be listed
+		if patsErr != nil {
+			return patsErr
+		}
+		expiry := ttlDays(ttls.PAT, gh.DefaultPATExpiry)
+		err := gh.ValidatePATExpiry(expiry)
+		if err != nil {
+			return err
+		}
+		return m.setBotCommitSecret(ctx, repo.Name, track, secret.Name, expiry, pats)
+
+	default:
+		return fmt.Errorf("unknown secret provider '%s'", secret.Provider)
+	}
+}
+
+// ttlDays converts a lifetime in days from the config into a duration, returning the
+// fallback if the lifetime is unset.
+func ttlDays(days int, fallback time.Duration) time.Duration {
+	if days == 0 {
+		return fallback
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// permissionProfile returns the store permission profile for a store secret, either
+// from the named profiles in the config, or the store's default for the secret's channel.
+func (m *Manager) permissionProfile(storeType store.StoreType, secret config.Secret) (store.PermissionProfile, error) {
+	if secret.Profile == "" {
+		return storeType.DefaultPermissionProfile(secret.Channel)
+	}
+
+	// Config keys are case-insensitive, and are lowercased when the config is parsed.
+	permissions, ok := m.config.PermissionProfiles[strings.ToLower(secret.Profile)]
+	if !ok {
+		return store.PermissionProfile{}, fmt.Errorf("unknown permission profile '%s'", secret.Profile)
+	}
+
+	return storeType.NewPermissionProfile(secret.Profile, permissions)
+}
+
+// accountErrors are the store errors caused by a problem with the store account
+// itself, which will cause every subsequent login to the store to fail too.
+var accountErrors = []error{
+	store.ErrInvalidCredentials,
+	store.ErrTwoFactorRequired,
+	store.ErrTwoFactorFailed,
+	store.ErrAccountSuspended,
+}
+
+// recordStoreFailure records the error if it means that no more tokens can be issued
+// on the store, so that the remaining store secrets fail fast rather than repeating
+// failed logins, which risks the account being locked.
+func (m *Manager) recordStoreFailure(storeType store.StoreType, err error) {
+	for _, accountErr := range accountErrors {
+		if errors.Is(err, accountErr) {
+			m.storeFailuresMu.Lock()
+			defer m.storeFailuresMu.Unlock()
+
+			if m.storeFailures[storeType.Name] == nil {
+				slog.Error("unable to login to store, skipping remaining store secrets", "store", storeType.Name, "error", err.Error())
+				m.storeFailures[storeType.Name] = err
+			}
+			return
+		}
+	}
+}
+
+// storeFailure returns an error if a previous failure means that no more tokens can be
+// issued on the store.
+func (m *Manager) storeFailure(storeType store.StoreType) error {
+	m.storeFailuresMu.Lock()
+	defer m.storeFailuresMu.Unlock()
+
+	err := m.storeFailures[storeType.Name]
+	if err != nil {
+		return fmt.Errorf("not attempted after an earlier %s login failed: %w", storeType.Name, err)
+	}
+	return nil
+}
+
+// setLaunchpadSecret is helper that sets the Launchpad credentials secret for a given repo/environment.
+func (m *Manager) setLaunchpadSecret(ctx context.Context, repo string, track config.Track, secretName string) error {
+	if m.options.DryRun {
+		m.plan.Add(repo, track.Environment, "set secret", secretName)
+		return nil
+	}
+
+	err := m.repoClient.SetEnvSecret(ctx, repo, track, m.config.PolicyFor(track), secretName, m.credentials.Launchpad)
+	if err != nil {
+		return fmt.Errorf("failed to set %s secret: %w", secretName, err)
+	}
+
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+	slog.Info("secret set", "repo", fullName, "secret_name", secretName, "environment", track.Environment)
+
+	// The Launchpad credential is shared rather than issued per repo, so it's only
+	// recorded when it changes.
+	fingerprint := ledger.Fingerprint(m.credentials.Launchpad)
+	if e, ok := m.ledger.Latest(repo, track.Environment, secretName); ok && e.Fingerprint == fingerprint {
+		return nil
+	}
+
+	return m.recordIssued(ledger.Entry{
+		Repo:        repo,
+		Track:       track.Name,
+		Environment: track.Environment,
+		Secret:      secretName,
+		Fingerprint: fingerprint,
+		IssuedAt:    time.Now(),
+	})
+}
+
+// setStoreSecret is helper that generates and sets the store secret for a given package/track/environment.
+// Once the secret is set, the store tokens previously recorded in the ledger for the secret are revoked.
+func (m *Manager) setStoreSecret(ctx context.Context, storeClient *store.StoreClient, repo string, packages []store.Package, track config.Track, secretName, channel string, profile store.PermissionProfile, ttl time.Duration) error {
+	description := store.TokenDescription(repo, track.Name, secretName)
+	qualified, _ := store.QualifyChannel(track.Name, channel)
+
+	names := []string{}
+	for _, p := range packages {
+		names = append(names, p.String())
+	}
+
+	// The scope of the token is recorded in the ledger, so that it's reissued if the
+	// config changes.
+	scope := ledger.Entry{
+		Channel:     qualified,
+		Profile:     profile.Name,
+		Permissions: profile.Permissions,
+		Packages:    names,
+	}
+
+	err := m.checkRenewal(ctx, repo, track, secretName, scope, ttl)
+	if err != nil {
+		return err
+	}
+
+	if m.options.DryRun {
+		m.plan.Add(repo, track.Environment, "mint store token", fmt.Sprintf("%s, packages: %s, channel: %s, profile: %s (%s), ttl: %d days",
+			description, strings.Join(names, ","), qualified, profile.Name, strings.Join(profile.Permissions, ","), int(ttl.Hours()/24)))
+		m.plan.Add(repo, track.Environment, "set secret", secretName)
+		if ids := m.issuedTokenIDs(repo, track, secretName); len(ids) > 0 {
+			m.plan.Add(repo, track.Environment, "revoke superseded store tokens", fmt.Sprintf("%s (ids %s)", description, strings.Join(ids, ",")))
+		}
+		return nil
+	}
+
+	issuedAt := time.Now()
+	token, err := storeClient.GenerateStoreToken(description, packages, track.Name, channel, profile, ttl)
+	if err != nil {
+		return err
+	}
+
+	// If the secret can't be set, revoke the token just minted so that it isn't left
+	// valid on the publisher account.
+	rb := &rollback{}
+	rb.add("revoke store token "+description, func() error {
+		_, err := storeClient.RevokeTokens([]string{token.SessionID})
+		return err
+	})
+
+	err = m.repoClient.SetEnvSecret(ctx, repo, track, m.config.PolicyFor(track), secretName, token.Value)
+	if err != nil {
+		return rb.run(fmt.Errorf("failed to set %s secret: %w", secretName, err))
+	}
+
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+	slog.Info("secret set", "repo", fullName, "secret_name", secretName, "environment", track.Environment)
+
+	// Collect the IDs of tokens previously issued for the secret, before recording the
+	// new one.
+	previousIDs := m.issuedTokenIDs(repo, track, secretName)
+
+	err = m.recordIssued(ledger.Entry{
+		Repo:        repo,
+		Track:       track.Name,
+		Environment: track.Environment,
+		Secret:      secretName,
+		Description: description,
+		TokenID:     token.SessionID,
+		Store:       storeClient.StoreType().Name,
+		Channel:     scope.Channel,
+		Profile:     scope.Profile,
+		Permissions: scope.Permissions,
+		Packages:    scope.Packages,
+		Fingerprint: ledger.Fingerprint(token.Value),
+		IssuedAt:    issuedAt,
+		ExpiresAt:   issuedAt.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(previousIDs) == 0 {
+		return nil
+	}
+
+	revoked, err := storeClient.RevokeTokens(previousIDs)
+	if err != nil {
+		return fmt.Errorf("secret set, but failed to revoke superseded store tokens: %w", err)
+	}
+
+	for _, t := range revoked {
+		slog.Info("store token revoked", "repo", fullName, "description", t.Description, "session_id", t.SessionID, "created_at", t.CreatedAt)
+	}
+
+	return nil
+}
+
+// issuedTokenIDs returns the session IDs of the store tokens recorded in the ledger for
+// the specified secret.
+func (m *Manager) issuedTokenIDs(repo string, track config.Track, secretName string) []string {
+	ids := []string{}
+	for _, e := range m.ledger.History(repo, track.Environment, secretName) {
+		if e.TokenID != "" {
+			ids = append(ids, e.TokenID)
+		}
+	}
+	return ids
+}
+
+// revokeLegacyStoreTokens revokes the store tokens issued for a track by earlier versions
+// of tokenator, whose description was shared by every secret of the track. They are only
+// revoked once every store secret of the track has been reissued with a token recorded
+// in the ledger, so that no secret is left holding a revoked token. The revocation is
+// recorded in the ledger, so that it only happens once.
+func (m *Manager) revokeLegacyStoreTokens(storeClient *store.StoreClient, repo config.Repo, track config.Track) error {
+	if m.legacyTokensRevoked(repo.Name, track) {
+		return nil
+	}
+
+	for _, secret := range repo.SecretsFor(track) {
+		if secret.Provider != config.ProviderStore {
+			continue
+		}
+
+		e, ok := m.ledger.Latest(repo.Name, track.Environment, secret.Name)
+		if !ok || e.TokenID == "" {
+			return nil
+		}
+	}
+
+	description := store.LegacyTokenDescription(repo.Name, track.Name)
+	if m.options.DryRun {
+		m.plan.Add(repo.Name, track.Environment, "revoke legacy store tokens", description)
+		return nil
+	}
+
+	revoked, err := storeClient.RevokeByDescription(description)
+	if err != nil {
+		return fmt.Errorf("secret set, but failed to revoke legacy store tokens: %w", err)
+	}
+
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo.Name)
+	for _, t := range revoked {
+		slog.Info("legacy store token revoked", "repo", fullName, "description", t.Description, "session_id", t.SessionID, "created_at", t.CreatedAt)
+	}
+
+	err = m.ledger.Record(ledger.Entry{
+		Repo:          repo.Name,
+		Track:         track.Name,
+		Environment:   track.Environment,
+		Description:   description,
+		Store:         storeClient.StoreType().Name,
+		LegacyRevoked: true,
+		IssuedAt:      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("legacy store tokens revoked, but failed to record it in the ledger: %w", err)
+	}
+
+	return nil
+}
+
+// legacyTokensRevoked reports whether the legacy store tokens of the specified track are
+// recorded in the ledger as having been revoked.
+func (m *Manager) legacyTokensRevoked(repo string, track config.Track) bool {
+	return slices.ContainsFunc(m.ledger.Entries(), func(e ledger.Entry) bool {
+		return e.LegacyRevoked && e.Repo == repo && e.Environment == track.Environment && e.Track == track.Name
+	})
+}
+
+// setBotCommitSecret is helper that generates and sets the bot commit secret for a given repo/environment.
+func (m *Manager) setBotCommitSecret(ctx context.Context, repo string, track config.Track, secretName string, expiry time.Duration, pats *patList) error {
+	fullName := fmt.Sprintf("%s/%s", m.config.Org, repo)
+
+	tokenRepos := []string{fullName, "snapcrafters/ci-screenshots"}
+	patName := fmt.Sprintf("token8r-%s-%s-%s", m.id, repo, track.Name)
+
+	err := m.checkRenewal(ctx, repo, track, secretName, ledger.Entry{}, expiry)
+	if err != nil {
+		return err
+	}
+
+	if m.options.DryRun {
+		m.plan.Add(repo, track.Environment, "create personal access token", fmt.Sprintf("%s, repos: %s, expiry: %d days", patName, strings.Join(tokenRepos, ","), int(expiry.Hours()/24)))
+		m.plan.Add(repo, track.Environment, "approve personal access token request", fmt.Sprintf("%s, org: %s", patName, m.config.Org))
+		m.plan.Add(repo, track.Environment, "set secret", secretName)
+		previous := fmt.Sprintf("token8r-*-%s-%s from previous runs", repo, track.Name)
+		if e, ok := m.ledger.Latest(repo, track.Environment, secretName); ok {
+			previous = fmt.Sprintf("%s (id %s), and any other %s", e.PATName, e.PATID, previous)
+		}
+		m.plan.Add(repo, track.Environment, "delete personal access tokens", previous)
+		return nil
+	}
+
+	// Limit the number of operations made concurrently with the Github web session.
+	m.patLimiter.acquire()
+	defer m.patLimiter.release()
+
+	// Create the access token on Github, which triggers a PAT approval in the org
+	issuedAt := time.Now()
+	pat, err := m.patClient.Create(patName, tokenRepos, m.config.Org, expiry)
+	if err != nil {
+		return fmt.Errorf("failed to create personal access token: %w", err)
+	}
+
+	// If any of the following steps fail, the new token is deleted so that it isn't
+	// left orphaned on the bot account.
+	rb := &rollback{}
+	rb.add("delete personal access token "+pat.Name, func() error { return pat.Delete(m.patClient) })
+
+	// Approve the PAT request we just triggered so the new token is active
+	err = m.orgClient.ApprovePATRequest(ctx, repo)
+	if err != nil {
+		rb.add("deny personal access token request", func() error { return m.orgClient.DenyPATRequest(ctx, repo) })
+		return rb.run(fmt.Errorf("failed to approve personal access token request: %w", err))
+	}
+
+	// Set the SNAPCRAFTERS_BOT_COMMIT secret
+	err = m.repoClient.SetEnvSecret(ctx, repo, track, m.config.PolicyFor(track), secretName, pat.Token)
+	if err != nil {
+		return rb.run(fmt.Errorf("failed to set %s secret: %w", secretName, err))
+	}
+
+	slog.Info("secret set", "repo", fullName, "secret_name", secretName, "environment", track.Environment)
+
+	// Collect the IDs of tokens previously issued for this repo/environment, before
+	// recording the new one.
+	previousIDs := []string{}
+	for _, e := range m.ledger.History(repo, track.Environment, secretName) {
+		previousIDs = append(previousIDs, e.PATID)
+	}
+
+	err = m.recordIssued(ledger.Entry{
+		Repo:        repo,
+		Track:       track.Name,
+		Environment: track.Environment,
+		Secret:      secretName,
+		PATID:       pat.ID,
+		PATName:     pat.Name,
+		Fingerprint: ledger.Fingerprint(pat.Token),
+		IssuedAt:    issuedAt,
+		ExpiresAt:   issuedAt.Add(expiry),
+	})
+	if err != nil {
+		return err
+	}
+
+	recordedIDs := m.recordedPATIDs()
+	superseded := pats.take(func(p *gh.PAT) bool {
+		return p.ID != pat.ID && m.patSuperseded(p, repo, track, previousIDs, recordedIDs)
+	})
+
+	// Iterate through the list of PATs, cleaning up redundant secrets where necessary
+	for _, pat := range superseded {
+		err := pat.Delete(m.patClient)
+		if err != nil {
+			return fmt.Errorf("failed to delete personal access token: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// patSuperseded reports whether an existing PAT is superseded by a new one issued for
+// the specified repo and track. A PAT is superseded if it was previously recorded in the
+// ledger for the same secret. Failing that, a PAT that isn't recorded in the ledger for
+// any secret is superseded if it has the exact name given by an earlier run for the same
+// repo and track, as PATs were issued before the ledger existed.
+func (m *Manager) patSuperseded(p *gh.PAT, repo string, track config.Track, previousIDs []string, recordedIDs []string) bool {
+	if slices.Contains(previousIDs, p.ID) {
+		return true
+	}
+	if slices.Contains(recordedIDs, p.ID) {
+		return false
+	}
+
+	match := patNamePattern(repo, track.Name).FindStringSubmatch(p.Name)
+	return match != nil && match[1] != m.id
+}
+
+// patNamePattern matches the name given to a PAT for the specified repo and track by any
+// run of tokenator, capturing the ID of the run.
+func patNamePattern(repo, track string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("^token8r-([0-9a-f]{4})-%s$", regexp.QuoteMeta(repo+"-"+track)))
+}
+
+// recordedPATIDs returns the IDs of every PAT recorded in the ledger, for any secret.
+func (m *Manager) recordedPATIDs() []string {
+	ids := []string{}
+	for _, e := range m.ledger.Entries() {
+		if e.PATID != "" {
+			ids = append(ids, e.PATID)
+		}
+	}
+	return ids
+}
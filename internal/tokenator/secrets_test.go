@@ -0,0 +1,119 @@
+package tokenator
+
+import (
+	"testing"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/gh"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+func TestPATSuperseded(t *testing.T) {
+	l, _ := ledger.New(ledger.NewMemoryBackend())
+	for _, e := range []ledger.Entry{
+		{Repo: "foo", Environment: "Candidate Branch", Secret: "SNAPCRAFTERS_BOT_COMMIT", PATID: "1", PATName: "token8r-abcd-foo-latest", IssuedAt: issued},
+		{Repo: "bar-foo", Environment: "Candidate Branch", Secret: "SNAPCRAFTERS_BOT_COMMIT", PATID: "2", PATName: "token8r-abcd-bar-foo-latest", IssuedAt: issued},
+	} {
+		err := l.Record(e)
+		if err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+
+	m := &Manager{id: "beef", ledger: l}
+	track := config.Track{Name: "latest", Environment: "Candidate Branch"}
+	previousIDs := []string{"1"}
+	recordedIDs := m.recordedPATIDs()
+
+	tests := []struct {
+		name     string
+		pat      gh.PAT
+		expected bool
+	}{
+		{name: "recorded for the secret", pat: gh.PAT{ID: "1", Name: "token8r-abcd-foo-latest"}, expected: true},
+		{name: "recorded for another repo", pat: gh.PAT{ID: "2", Name: "token8r-abcd-bar-foo-latest"}, expected: false},
+		{name: "unrecorded with the same name", pat: gh.PAT{ID: "3", Name: "token8r-1234-foo-latest"}, expected: true},
+		{name: "unrecorded for a repo with the same suffix", pat: gh.PAT{ID: "4", Name: "token8r-1234-bar-foo-latest"}, expected: false},
+		{name: "unrecorded for a track with the same prefix", pat: gh.PAT{ID: "5", Name: "token8r-1234-foo-latest-1.0"}, expected: false},
+		{name: "issued by this run", pat: gh.PAT{ID: "6", Name: "token8r-beef-foo-latest"}, expected: false},
+		{name: "not issued by tokenator", pat: gh.PAT{ID: "7", Name: "my-foo-latest"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			superseded := m.patSuperseded(&tt.pat, "foo", track, previousIDs, recordedIDs)
+			if superseded != tt.expected {
+				t.Errorf("expected superseded to be %t, got %t", tt.expected, superseded)
+			}
+		})
+	}
+}
+
+func TestRevokeLegacyStoreTokens(t *testing.T) {
+	repo := config.Repo{
+		Name: "gimp",
+		Secrets: []config.Secret{
+			{Name: "SNAP_STORE_EDGE", Provider: config.ProviderStore, Channel: "edge"},
+			{Name: "SNAP_STORE_STABLE", Provider: config.ProviderStore, Channel: "stable"},
+		},
+	}
+	track := config.Track{Name: "latest", Environment: "Candidate Branch"}
+
+	tests := []struct {
+		name     string
+		entries  []ledger.Entry
+		expected int
+	}{
+		{
+			name: "secret not yet reissued",
+			entries: []ledger.Entry{
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_EDGE", 0),
+			},
+			expected: 0,
+		},
+		{
+			name: "every secret reissued",
+			entries: []ledger.Entry{
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_EDGE", 0),
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0),
+			},
+			expected: 1,
+		},
+		{
+			name: "already revoked",
+			entries: []ledger.Entry{
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_EDGE", 0),
+				storeEntry("gimp", "latest", "Candidate Branch", "SNAP_STORE_STABLE", 0),
+				{Repo: "gimp", Track: "latest", Environment: "Candidate Branch", Description: "tokenator-gimp-latest", LegacyRevoked: true, IssuedAt: issued},
+			},
+			expected: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := ledger.New(ledger.NewMemoryBackend())
+			for _, e := range tt.entries {
+				err := l.Record(e)
+				if err != nil {
+					t.Fatalf("Record returned error: %v", err)
+				}
+			}
+
+			m := &Manager{ledger: l, plan: &Plan{}, options: Options{DryRun: true}}
+
+			err := m.revokeLegacyStoreTokens(nil, repo, track)
+			if err != nil {
+				t.Fatalf("revokeLegacyStoreTokens returned error: %v", err)
+			}
+
+			actions := m.plan.Actions()
+			if len(actions) != tt.expected {
+				t.Fatalf("expected %d planned actions, got %+v", tt.expected, actions)
+			}
+			if len(actions) > 0 && actions[0].Detail != "tokenator-gimp-latest" {
+				t.Errorf("expected the legacy description to be revoked, got '%s'", actions[0].Detail)
+			}
+		})
+	}
+}
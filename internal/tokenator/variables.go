@@ -0,0 +1,107 @@
+package tokenator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+// setVariables reconciles the Actions variables in the environment for the specified
+// track with those declared in the config, recording the outcome for each variable in
+// the manager's report. Variables previously set by tokenator, according to the ledger,
+// are deleted once they are no longer declared. Any other variables are left untouched.
+func (m *Manager) setVariables(ctx context.Context, repo config.Repo, track config.Track) {
+	variables := repo.VariablesFor(track)
+	managed := m.ledger.Variables(repo.Name, track.Environment)
+
+	names := []string{}
+	for name := range variables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	undeclared := []string{}
+	for name := range managed {
+		if _, ok := variables[name]; !ok {
+			undeclared = append(undeclared, name)
+		}
+	}
+	slices.Sort(undeclared)
+
+	if len(names) == 0 && len(undeclared) == 0 {
+		return
+	}
+
+	if m.options.DryRun {
+		for _, name := range names {
+			m.plan.Add(repo.Name, track.Environment, "set variable", fmt.Sprintf("%s=%s", name, variables[name]))
+		}
+		for _, name := range undeclared {
+			m.plan.Add(repo.Name, track.Environment, "delete variable", name)
+		}
+		return
+	}
+
+	err := m.repoClient.SetEnvVariables(ctx, repo.Name, track.Environment, variables, undeclared)
+	if err == nil {
+		err = m.recordVariables(repo.Name, track, variables, undeclared, managed)
+	}
+	if err != nil {
+		fullName := fmt.Sprintf("%s/%s", m.config.Org, repo.Name)
+		slog.Error("failed to set variables", "repo", fullName, "environment", track.Environment, "error", err.Error())
+	}
+
+	for _, name := range names {
+		m.report.AddVariable(repo.Name, track, name, false, err)
+	}
+	for _, name := range undeclared {
+		m.report.AddVariable(repo.Name, track, name, true, err)
+	}
+}
+
+// recordVariables adds an entry to the ledger for each declared variable that is new or
+// whose value has changed since it was last recorded, and marks each undeclared variable
+// as pruned, so that the ledger holds the variables managed in the environment.
+func (m *Manager) recordVariables(repo string, track config.Track, variables map[string]string, undeclared []string, managed map[string]ledger.Entry) error {
+	now := time.Now()
+
+	for name, value := range variables {
+		fingerprint := ledger.Fingerprint(value)
+		if e, ok := managed[name]; ok && e.Fingerprint == fingerprint {
+			continue
+		}
+
+		err := m.ledger.Record(ledger.Entry{
+			Repo:        repo,
+			Track:       track.Name,
+			Environment: track.Environment,
+			Variable:    name,
+			Fingerprint: fingerprint,
+			IssuedAt:    now,
+		})
+		if err != nil {
+			return fmt.Errorf("variables set, but failed to record them in the ledger: %w", err)
+		}
+	}
+
+	for _, name := range undeclared {
+		err := m.ledger.Record(ledger.Entry{
+			Repo:        repo,
+			Track:       track.Name,
+			Environment: track.Environment,
+			Variable:    name,
+			Pruned:      true,
+			IssuedAt:    now,
+		})
+		if err != nil {
+			return fmt.Errorf("variables deleted, but failed to record them in the ledger: %w", err)
+		}
+	}
+
+	return nil
+}
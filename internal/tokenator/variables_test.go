@@ -0,0 +1,126 @@
+package tokenator
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+)
+
+// variablesRepo returns a repo declaring a single variable, whose environment also holds
+// a variable previously set by tokenator, and another set by hand.
+func variablesRepo(rc *fakeRepoClient) (config.Repo, config.Track, ledger.Entry) {
+	track := config.Track{Name: "latest", Branch: "candidate", Environment: "Candidate Branch"}
+	repo := config.Repo{
+		Name:      "gimp",
+		Tracks:    []config.Track{track},
+		Variables: map[string]string{"channel": "${track}/${branch}"},
+	}
+
+	rc.variables["gimp/Candidate Branch"] = map[string]string{"OLD_CHANNEL": "latest/edge", "MANUAL": "kept"}
+
+	managed := ledger.Entry{
+		Repo:        "gimp",
+		Track:       "latest",
+		Environment: "Candidate Branch",
+		Variable:    "OLD_CHANNEL",
+		Fingerprint: ledger.Fingerprint("latest/edge"),
+		IssuedAt:    issued,
+	}
+
+	return repo, track, managed
+}
+
+func TestSetVariables(t *testing.T) {
+	rc := newFakeRepoClient()
+	repo, track, managed := variablesRepo(rc)
+
+	m, err := newTestManager(config.Config{Org: "snapcrafters"}, rc, managed)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	m.setVariables(context.Background(), repo, track)
+
+	// Only the variable recorded in the ledger is deleted, while the one set by hand is
+	// left alone.
+	expected := map[string]string{"CHANNEL": "latest/candidate", "MANUAL": "kept"}
+	if !maps.Equal(rc.variables["gimp/Candidate Branch"], expected) {
+		t.Errorf("expected variables %v, got %v", expected, rc.variables["gimp/Candidate Branch"])
+	}
+
+	recorded := []string{}
+	for name := range m.ledger.Variables("gimp", "Candidate Branch") {
+		recorded = append(recorded, name)
+	}
+	if !slices.Equal(recorded, []string{"CHANNEL"}) {
+		t.Errorf("expected only CHANNEL to be recorded as managed, got %v", recorded)
+	}
+
+	statuses := []string{}
+	for _, r := range m.report.Results() {
+		statuses = append(statuses, r.Secret+" "+r.Status())
+	}
+	if !slices.Equal(statuses, []string{"CHANNEL ok", "OLD_CHANNEL pruned"}) {
+		t.Errorf("expected CHANNEL to be set and OLD_CHANNEL pruned, got %v", statuses)
+	}
+}
+
+func TestSetVariablesUnchanged(t *testing.T) {
+	rc := newFakeRepoClient()
+	repo, track, _ := variablesRepo(rc)
+
+	m, err := newTestManager(config.Config{Org: "snapcrafters"}, rc)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	m.setVariables(context.Background(), repo, track)
+	m.setVariables(context.Background(), repo, track)
+
+	// A variable is only recorded again when its value changes.
+	history := 0
+	for _, e := range m.ledger.Entries() {
+		if e.Variable == "CHANNEL" {
+			history++
+		}
+	}
+	if history != 1 {
+		t.Errorf("expected CHANNEL to be recorded once, got %d entries", history)
+	}
+
+	// Without a ledger entry, the variable set by hand is never deleted.
+	if _, ok := rc.variables["gimp/Candidate Branch"]["OLD_CHANNEL"]; !ok {
+		t.Errorf("expected the unrecorded variable to be kept")
+	}
+}
+
+func TestSetVariablesDryRun(t *testing.T) {
+	rc := newFakeRepoClient()
+	repo, track, managed := variablesRepo(rc)
+
+	m, err := newTestManager(config.Config{Org: "snapcrafters"}, rc, managed)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	m.options.DryRun = true
+
+	m.setVariables(context.Background(), repo, track)
+
+	actions := []string{}
+	for _, a := range m.plan.Actions() {
+		actions = append(actions, a.Kind+" "+a.Detail)
+	}
+
+	expected := []string{"set variable CHANNEL=latest/candidate", "delete variable OLD_CHANNEL"}
+	if !slices.Equal(actions, expected) {
+		t.Errorf("expected actions %v, got %v", expected, actions)
+	}
+
+	if len(rc.variables["gimp/Candidate Branch"]) != 2 {
+		t.Errorf("expected no variables to change in dry-run, got %v", rc.variables["gimp/Candidate Branch"])
+	}
+}
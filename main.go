@@ -6,7 +6,10 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/snapcrafters/tokenator/internal/config"
+	"github.com/snapcrafters/tokenator/internal/ledger"
+	"github.com/snapcrafters/tokenator/internal/store"
 	"github.com/snapcrafters/tokenator/internal/tokenator"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -18,6 +21,14 @@ var (
 
 	repositories []string
 	verbose      bool
+	dryRun       bool
+	force        bool
+
+	prune             bool
+	pruneEnvironments bool
+
+	concurrency    int
+	patConcurrency int
 )
 
 var shortDesc = "A utility for distributing credentials to Snapcrafters repositories."
@@ -43,6 +54,10 @@ The following environment variables must be set:
 	- TOKENATOR_SNAPCRAFTERS_ORG_PAT - Github Personal Access Token with Snapcrafters org privileges
 	- TOKENATOR_SNAPCRAFT_LOGIN - Snap Store login
 	- TOKENATOR_SNAPCRAFT_PASSWORD - Snap Store password
+	- TOKENATOR_SNAPCRAFT_TOTP_SECRET - (Optional) Ubuntu One TOTP secret for the Snap Store login
+	- TOKENATOR_CHARMHUB_LOGIN - (Optional) Charmhub login, if different from the Snap Store
+	- TOKENATOR_CHARMHUB_PASSWORD - (Optional) Charmhub password, if different from the Snap Store
+	- TOKENATOR_CHARMHUB_TOTP_SECRET - (Optional) Ubuntu One TOTP secret for the Charmhub login
 	- TOKENATOR_LP_AUTH - Launchpad Remote Build auth file contents
 	- TOKENATOR_SNAPCRAFTERS_BOT_LOGIN - Github login for the "snapcrafters-bot" user
 	- TOKENATOR_SNAPCRAFTERS_BOT_PASSWORD - Github password for the "snapcrafters-bot" user
@@ -74,14 +89,30 @@ var rootCmd = &cobra.Command{
 			return fmt.Errorf("failed to parse credentials: %w", err)
 		}
 
-		mgr := tokenator.NewManager(*cfg, creds)
+		backend, err := ledger.NewBackend(cfg.State)
+		if err != nil {
+			return fmt.Errorf("failed to configure state backend: %w", err)
+		}
+
+		l, err := ledger.New(backend)
+		if err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
 
-		err = mgr.Process(repositories)
+		mgr, err := tokenator.NewManager(*cfg, creds, tokenator.Options{
+			DryRun:            dryRun,
+			Force:             force,
+			Concurrency:       concurrency,
+			PATConcurrency:    patConcurrency,
+			Prune:             prune || pruneEnvironments,
+			PruneEnvironments: pruneEnvironments,
+			Ledger:            l,
+		})
 		if err != nil {
-			slog.Error(err.Error())
+			return err
 		}
 
-		return nil
+		return mgr.Process(repositories)
 	},
 }
 
@@ -99,7 +130,13 @@ func main() {
 	viper.SetEnvPrefix("TOKENATOR")
 
 	rootCmd.Flags().StringSliceVarP(&repositories, "repos", "r", []string{}, "comma-separated subset of repos to process. If omitted all configured repos will be processed.")
-	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose logging")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose logging")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the actions that would be taken without making any changes")
+	rootCmd.Flags().BoolVar(&force, "force", false, "rotate all secrets, even those not yet due for renewal")
+	rootCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 1, "maximum number of repos to process concurrently")
+	rootCmd.Flags().IntVar(&patConcurrency, "pat-concurrency", 1, "maximum number of concurrent operations using the Github web session")
+	rootCmd.Flags().BoolVar(&prune, "prune", false, "delete managed secrets that are no longer declared in the config, revoking their tokens")
+	rootCmd.Flags().BoolVar(&pruneEnvironments, "prune-environments", false, "as --prune, but also delete environments no longer used by any track")
 	err := rootCmd.Execute()
 	if err != nil {
 		slog.Error(err.Error())
@@ -126,12 +163,25 @@ func parseCreds() (config.Credentials, error) {
 		viper.MustBindEnv(cred)
 	}
 
+	// The store TOTP secrets are only needed for accounts with two-factor authentication
+	// enabled. Charmhub credentials are optional, and default to those for the Snap Store.
+	viper.MustBindEnv("snapcraft_totp_secret")
+	viper.MustBindEnv("charmhub_login")
+	viper.MustBindEnv("charmhub_password")
+	viper.MustBindEnv("charmhub_totp_secret")
+
 	creds := config.Credentials{
 		GithubToken: viper.GetString("snapcrafters_org_pat"),
 		Launchpad:   viper.GetString("lp_auth"),
 		SnapStore: config.LoginCredentials{
-			Login:    viper.GetString("snapcraft_login"),
-			Password: viper.GetString("snapcraft_password"),
+			Login:      viper.GetString("snapcraft_login"),
+			Password:   viper.GetString("snapcraft_password"),
+			TOTPSecret: viper.GetString("snapcraft_totp_secret"),
+		},
+		Charmhub: config.LoginCredentials{
+			Login:      viper.GetString("charmhub_login"),
+			Password:   viper.GetString("charmhub_password"),
+			TOTPSecret: viper.GetString("charmhub_totp_secret"),
 		},
 		Bot: config.LoginCredentials{
 			Login:      viper.GetString("snapcrafters_bot_login"),
@@ -147,6 +197,15 @@ func parseCreds() (config.Credentials, error) {
 	return creds, nil
 }
 
+// logout revokes the session a command used to manage the store account. A failure is
+// only logged, as the session token expires shortly after anyway.
+func logout(storeClient *store.StoreClient) {
+	err := storeClient.Logout()
+	if err != nil {
+		slog.Warn("failed to log out of store", "error", err.Error())
+	}
+}
+
 // parseConfig reads in the config and parses it into the correct format
 func parseConfig() (*config.Config, error) {
 	err := viper.ReadInConfig()
@@ -157,11 +216,20 @@ func parseConfig() (*config.Config, error) {
 		return nil, errors.New("error parsing tokenator config file")
 	}
 
+	// Decode using the yaml struct tags, so that keys such as 'renewal_window' map
+	// correctly onto the config structure.
 	conf := &config.Config{}
-	err = viper.Unmarshal(conf)
+	err = viper.Unmarshal(conf, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+	})
 	if err != nil {
 		return nil, errors.New("error parsing tokenator config file")
 	}
 
+	err = conf.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tokenator config file: %w", err)
+	}
+
 	return conf, nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/snapcrafters/tokenator/internal/store"
+	"github.com/snapcrafters/tokenator/internal/tokenator"
+	"github.com/spf13/cobra"
+)
+
+var refreshStoreName string
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh [token]",
+	Short: "Refresh the discharge macaroon of a store token",
+	Long: `Refresh the discharge macaroon of a store token with Ubuntu One, without logging in
+with the store password, and print the refreshed token.
+
+The root macaroon, and so the token's packages, channels, permissions and expiry, are
+unchanged. The token is read from standard input if it is not given as an argument.`,
+	Args: cobra.MaximumNArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokenator.SetupLogger(verbose)
+
+		encoded, err := readToken(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := parseConfig()
+		if err != nil {
+			return fmt.Errorf("failed to parse config: %w", err)
+		}
+
+		creds, err := parseCreds()
+		if err != nil {
+			return fmt.Errorf("failed to parse credentials: %w", err)
+		}
+
+		storeType, err := store.LookupStoreType(refreshStoreName)
+		if err != nil {
+			return err
+		}
+
+		storeClient, err := tokenator.NewStoreClient(*cfg, creds, storeType)
+		if err != nil {
+			return err
+		}
+
+		refreshed, err := storeClient.RefreshEncodedToken(encoded)
+		if err != nil {
+			return fmt.Errorf("failed to refresh token: %w", err)
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), refreshed)
+		return nil
+	},
+}
+
+func init() {
+	refreshCmd.Flags().StringVar(&refreshStoreName, "store", store.SnapStore.Name, "the store the token was issued on, either 'snap-store' or 'charmhub'")
+	rootCmd.AddCommand(refreshCmd)
+}
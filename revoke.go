@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/snapcrafters/tokenator/internal/store"
+	"github.com/snapcrafters/tokenator/internal/tokenator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	descriptions []string
+	storeName    string
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke store tokens by description",
+	Long: `Revoke every token issued on the store account with the given description. Tokens are
+revoked on the Snap Store, or on Charmhub with '--store charmhub'.
+
+Tokens issued by tokenator are described as 'tokenator-<repo>-<track>-<secret>'.`,
+	Args: cobra.NoArgs,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokenator.SetupLogger(verbose)
+
+		cfg, err := parseConfig()
+		if err != nil {
+			return fmt.Errorf("failed to parse config: %w", err)
+		}
+
+		creds, err := parseCreds()
+		if err != nil {
+			return fmt.Errorf("failed to parse credentials: %w", err)
+		}
+
+		storeType, err := store.LookupStoreType(storeName)
+		if err != nil {
+			return err
+		}
+
+		storeClient, err := tokenator.NewStoreClient(*cfg, creds, storeType)
+		if err != nil {
+			return err
+		}
+		defer logout(storeClient)
+
+		for _, description := range descriptions {
+			revoked, err := storeClient.RevokeByDescription(description)
+			if err != nil {
+				return fmt.Errorf("failed to revoke store tokens: %w", err)
+			}
+
+			slog.Info("store tokens revoked", "description", description, "count", len(revoked))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	revokeCmd.Flags().StringSliceVarP(&descriptions, "description", "d", []string{}, "comma-separated list of token descriptions to revoke")
+	revokeCmd.Flags().StringVar(&storeName, "store", store.SnapStore.Name, "the store the tokens were issued on, either 'snap-store' or 'charmhub'")
+	revokeCmd.MarkFlagRequired("description")
+	rootCmd.AddCommand(revokeCmd)
+}